@@ -0,0 +1,166 @@
+package subflow
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "github.com/bobcatalyst/flow"
+    "io"
+    "os"
+    "sync"
+    "syscall"
+    "time"
+)
+
+// attachPoll is how often an attached process and the files it writes to are checked.
+const attachPoll = 100 * time.Millisecond
+
+// Process monitors a running process that was not started by this package.
+type Process struct {
+    proc   *os.Process
+    out    flow.Stream[Message]
+    ctx    context.Context
+    cancel context.CancelFunc
+    done   chan struct{}
+    // pushing tracks the followed files and usage samplers, which must finish before the exit is emitted.
+    pushing sync.WaitGroup
+}
+
+// Attach monitors the already running process pid until it exits or ctx is done.
+// When it exits an ExitMessage is emitted, its code is -1 unless the process is a child of the current one on Linux, or on Windows.
+// The process is never waited for, so attaching to a child with a waiter of its own, e.g. a Cmd, does not take its exit status.
+func Attach(ctx context.Context, pid int) (*Process, error) {
+    proc, err := os.FindProcess(pid)
+    if err != nil {
+        return nil, err
+    } else if err := alive(proc); err != nil {
+        return nil, fmt.Errorf("attach to %d: %w", pid, err)
+    }
+
+    ctx, cancel := context.WithCancel(ctx)
+    p := &Process{
+        proc:   proc,
+        ctx:    ctx,
+        cancel: cancel,
+        done:   make(chan struct{}),
+    }
+    go p.monitor()
+    return p, nil
+}
+
+// Pid returns the process id of the attached process.
+func (p *Process) Pid() int { return p.proc.Pid }
+
+// Listen emits the output followed with FollowStdout and FollowStderr, the samples of SampleUsage and the exit of the process.
+// Like Cmd.Listen it is non buffered.
+func (p *Process) Listen(ctx context.Context) <-chan Message { return p.out.Listen(ctx) }
+
+// Done returns a channel that closes when the process exits or monitoring stops.
+func (p *Process) Done() <-chan struct{} { return p.done }
+
+// Signal sends sig to the process.
+func (p *Process) Signal(sig os.Signal) error { return p.proc.Signal(sig) }
+
+// Close stops monitoring the process, the process itself is left running.
+func (p *Process) Close() error {
+    p.cancel()
+    <-p.done
+    return p.proc.Release()
+}
+
+// FollowStdout emits what the process writes to the file or named pipe at path as StdoutMessages,
+// for processes whose stdout was redirected there. Only data written after the call is emitted.
+func (p *Process) FollowStdout(path string) error { return follow[StdoutMessage](p, path) }
+
+// FollowStderr is like FollowStdout for stderr.
+func (p *Process) FollowStderr(path string) error { return follow[StderrMessage](p, path) }
+
+// SampleUsage emits a UsageMessage with the resource usage of the process every interval until monitoring stops, like WithUsage.
+// It is only supported on Linux.
+func (p *Process) SampleUsage(interval time.Duration) error {
+    sample := newUsageSampler(p.proc.Pid)
+    if sample == nil {
+        return unsupported("SampleUsage")
+    } else if interval <= 0 {
+        return fmt.Errorf("invalid usage interval %s", interval)
+    } else if p.ctx.Err() != nil {
+        return ErrClosed
+    }
+
+    p.pushing.Add(1)
+    go func() {
+        defer p.pushing.Done()
+        t := time.NewTicker(interval)
+        defer t.Stop()
+        for {
+            select {
+            case <-p.ctx.Done():
+                return
+            case <-t.C:
+                // The exit is only noticed on the next poll, a process that is gone has nothing to sample.
+                if alive(p.proc) == nil {
+                    p.out.Push(sample())
+                }
+            }
+        }
+    }()
+    return nil
+}
+
+func (p *Process) monitor() {
+    defer close(p.done)
+    code := make(chan int, 1)
+    go func() { code <- waitAttached(p.ctx, p.proc) }()
+    select {
+    case <-p.ctx.Done():
+        p.out.Close()
+    case c := <-code:
+        p.cancel()
+        p.pushing.Wait()
+        p.out.Close(NewExitMessage(c))
+    }
+}
+
+// follow emits data written to the file at path as messages of kind K until the process exits.
+func follow[K StdioLike](p *Process, path string) error {
+    if p.ctx.Err() != nil {
+        return ErrClosed
+    }
+    f, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    // The offsets of a pipe count from where following started.
+    offset, err := f.Seek(0, io.SeekEnd)
+    if err != nil && !errors.Is(err, syscall.ESPIPE) {
+        return errors.Join(err, f.Close())
+    }
+
+    p.pushing.Add(1)
+    go func() {
+        defer p.pushing.Done()
+        defer f.Close()
+        buf := make([]byte, 32*1024)
+        for {
+            // Named pipes block until written to, files do not support deadlines and return io.EOF instead.
+            _ = f.SetReadDeadline(time.Now().Add(attachPoll))
+            n, err := f.Read(buf)
+            if n > 0 {
+                p.out.Push(NewStdioOffsetMessage[K](buf[:n], offset))
+                offset += int64(n)
+                continue
+            } else if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, os.ErrDeadlineExceeded) {
+                return
+            } else if p.ctx.Err() != nil {
+                // Everything written before monitoring stopped has been read.
+                return
+            } else if errors.Is(err, io.EOF) {
+                select {
+                case <-p.ctx.Done():
+                case <-time.After(attachPoll):
+                }
+            }
+        }
+    }()
+    return nil
+}
@@ -0,0 +1,43 @@
+//go:build linux
+
+package subflow
+
+import (
+    "bytes"
+    "os"
+    "strconv"
+    "syscall"
+    "unsafe"
+)
+
+// cldExited is the si_code of a child which exited on its own, see waitid(2).
+const cldExited = 1
+
+// exitedUnreaped reports if the process pid has exited but was not reaped yet, without reaping it.
+// The exit code is known for children of the current process, it is -1 for other processes and children killed by a signal.
+func exitedUnreaped(pid int) (code int, exited bool) {
+    var info [siginfoSize]byte
+    _, _, errno := syscall.Syscall6(syscall.SYS_WAITID, pPid, uintptr(pid), uintptr(unsafe.Pointer(&info)), wExited|wNoWait|syscall.WNOHANG, 0, 0)
+    if errno == 0 {
+        // The fields of a child's status follow si_signo, si_errno and si_code, aligned like a pointer.
+        fields := max(12, unsafe.Sizeof(uintptr(0))*2)
+        if childPid := *(*int32)(unsafe.Pointer(&info[fields])); childPid == 0 {
+            // The child is still running.
+            return 0, false
+        } else if *(*int32)(unsafe.Pointer(&info[8])) != cldExited {
+            return -1, true
+        }
+        return int(*(*int32)(unsafe.Pointer(&info[fields+8]))), true
+    }
+
+    // Not a child of the current process, which is a zombie until its own parent reaps it.
+    b, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+    if err != nil {
+        return 0, false
+    }
+    // The state follows the command name, which may contain spaces and parentheses.
+    if f := bytes.Fields(b[bytes.LastIndexByte(b, ')')+1:]); len(f) > 0 && string(f[0]) == "Z" {
+        return -1, true
+    }
+    return 0, false
+}
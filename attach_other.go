@@ -0,0 +1,29 @@
+//go:build !unix
+
+package subflow
+
+import (
+    "context"
+    "os"
+)
+
+// alive returns an error if proc has exited.
+// os.FindProcess already fails for processes which do not exist and waiting on any process is possible.
+func alive(*os.Process) error { return nil }
+
+// waitAttached waits for proc to exit and returns its exit code, or -1 if it can not be known.
+// On Windows waiting does not reap the process, so it does not interfere with other waiters.
+func waitAttached(ctx context.Context, proc *os.Process) int {
+    code := make(chan int, 1)
+    go func() {
+        if state, err := proc.Wait(); err == nil {
+            code <- state.ExitCode()
+        }
+    }()
+    select {
+    case c := <-code:
+        return c
+    case <-ctx.Done():
+        return -1
+    }
+}
@@ -0,0 +1,62 @@
+package subflow
+
+import (
+    "context"
+    "path/filepath"
+    "runtime"
+    "testing"
+    "time"
+)
+
+func TestAttachDetachedChild(t *testing.T) {
+    if runtime.GOOS != "linux" {
+        t.Skip("exit codes of attached processes are only known on Linux")
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+    handle := filepath.Join(t.TempDir(), "handle")
+    if _, err := StartDetached(NewCommandArgs("sh", []string{"-c", "sleep 0.2; exit 7"}), handle); err != nil {
+        t.Fatal(err)
+    }
+    p, err := AttachDetached(ctx, handle)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer p.Close()
+
+    var exit *ExitMessage
+    for msg := range p.Listen(ctx) {
+        if msg, ok := msg.(ExitMessage); ok {
+            exit = &msg
+        }
+    }
+    if exit == nil || exit.Code != 7 {
+        t.Errorf("exit = %+v, want code 7", exit)
+    }
+}
+
+func TestAttachKeepsExitOfCmd(t *testing.T) {
+    if runtime.GOOS != "linux" {
+        t.Skip("needs sh")
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+    cmd, err := New(ctx, NewCommandArgs("sh", []string{"-c", "sleep 0.2; exit 5"}))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := cmd.StartErr(); err != nil {
+        t.Fatal(err)
+    }
+    p, err := Attach(ctx, cmd.Pid())
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer p.Close()
+
+    <-p.Done()
+    <-cmd.Done()
+    if code := cmd.ProcessState().ExitCode(); code != 5 {
+        t.Errorf("exit code of the command = %d, want 5", code)
+    }
+}
@@ -0,0 +1,37 @@
+//go:build unix
+
+package subflow
+
+import (
+    "context"
+    "errors"
+    "os"
+    "syscall"
+    "time"
+)
+
+// alive returns an error if proc has exited.
+// Only a missing process has exited, a process which may not be signalled, e.g. of another user, is running.
+func alive(proc *os.Process) error {
+    if err := proc.Signal(syscall.Signal(0)); errors.Is(err, os.ErrProcessDone) || errors.Is(err, syscall.ESRCH) {
+        return err
+    }
+    return nil
+}
+
+// waitAttached waits for proc to exit and returns its exit code, or -1 if it can not be known.
+// It never reaps proc, which may be a child of the current process with a waiter of its own, e.g. a Cmd.
+func waitAttached(ctx context.Context, proc *os.Process) int {
+    for {
+        if code, ok := exitedUnreaped(proc.Pid); ok {
+            return code
+        } else if alive(proc) != nil {
+            return -1
+        }
+        select {
+        case <-ctx.Done():
+            return -1
+        case <-time.After(attachPoll):
+        }
+    }
+}
@@ -0,0 +1,110 @@
+package subflow
+
+import (
+    "bufio"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io"
+    "strconv"
+    "sync"
+)
+
+var _ Sink = (*AuditLog)(nil)
+
+// AuditLog is a Sink writing a tamper-evident log of the start, stdin, signals and exit of a command to w as JSON lines.
+// Every AuditRecord includes the hash of the previous one, so changing, removing or reordering records is detected by VerifyAudit.
+// An AuditLog can be shared by several commands to chain all of their records.
+type AuditLog struct {
+    w    io.Writer
+    lock sync.Mutex
+    seq  int
+    prev string
+    err  error
+}
+
+// AuditRecord is a record of an AuditLog.
+type AuditRecord struct {
+    Seq     int             `json:"seq"`
+    Message json.RawMessage `json:"message"`
+    // Prev is the hash of the previous record, empty for the first one.
+    Prev string `json:"prev"`
+    // Hash is the SHA-256 hash of the sequence number, the previous hash and the message.
+    Hash string `json:"hash"`
+}
+
+// NewAuditLog returns an AuditLog writing to w.
+// A non-empty prev continues the chain of a previous log ending with the hash prev.
+func NewAuditLog(w io.Writer, prev string) *AuditLog {
+    return &AuditLog{w: w, prev: prev}
+}
+
+// Hash returns the hash of the last record written, which continues the chain with NewAuditLog.
+func (a *AuditLog) Hash() string {
+    a.lock.Lock()
+    defer a.lock.Unlock()
+    return a.prev
+}
+
+func (a *AuditLog) Write(msg Message) error {
+    switch msg.(type) {
+    case StartMessage, StdinMessage, SignalMessage, ExitMessage:
+    default:
+        return nil
+    }
+    b, err := json.Marshal(msg)
+    if err != nil {
+        return err
+    }
+
+    a.lock.Lock()
+    defer a.lock.Unlock()
+    if a.err != nil {
+        return a.err
+    }
+    a.seq++
+    rec := AuditRecord{Seq: a.seq, Message: b, Prev: a.prev}
+    rec.Hash = rec.hash()
+    if b, a.err = json.Marshal(rec); a.err == nil {
+        _, a.err = a.w.Write(append(b, '\n'))
+    }
+    a.prev = rec.Hash
+    return a.err
+}
+
+// Close does not close the underlying writer, so the log can be continued by another command.
+func (a *AuditLog) Close() error {
+    a.lock.Lock()
+    defer a.lock.Unlock()
+    return a.err
+}
+
+func (rec AuditRecord) hash() string {
+    h := sha256.New()
+    writeKey(h, strconv.Itoa(rec.Seq), rec.Prev, string(rec.Message))
+    return hex.EncodeToString(h.Sum(nil))
+}
+
+// VerifyAudit verifies the chain of the audit log read from r, whose first record follows the hash prev.
+// It returns the hash of the last record, or an error naming the first record that does not match.
+func VerifyAudit(r io.Reader, prev string) (string, error) {
+    seq := 0
+    s := bufio.NewScanner(r)
+    s.Buffer(nil, 64*1024*1024)
+    for s.Scan() {
+        seq++
+        var rec AuditRecord
+        if err := json.Unmarshal(s.Bytes(), &rec); err != nil {
+            return prev, fmt.Errorf("audit record %d: %w", seq, err)
+        } else if rec.Seq != seq {
+            return prev, fmt.Errorf("audit record %d: has sequence number %d", seq, rec.Seq)
+        } else if rec.Prev != prev {
+            return prev, fmt.Errorf("audit record %d: does not follow the previous record", seq)
+        } else if rec.Hash != rec.hash() {
+            return prev, fmt.Errorf("audit record %d: hash mismatch", seq)
+        }
+        prev = rec.Hash
+    }
+    return prev, s.Err()
+}
@@ -0,0 +1,68 @@
+package subflow
+
+import (
+    "math"
+    "math/rand/v2"
+    "time"
+)
+
+// Backoff decides how long to wait before retrying a command.
+type Backoff interface {
+    // Delay returns the delay before the retry attempt, counting from 1 for the first retry.
+    Delay(attempt int) time.Duration
+}
+
+// BackoffFunc adapts a function to a Backoff.
+type BackoffFunc func(attempt int) time.Duration
+
+func (fn BackoffFunc) Delay(attempt int) time.Duration { return fn(attempt) }
+
+// ConstantBackoff waits d before every retry.
+func ConstantBackoff(d time.Duration) Backoff {
+    return BackoffFunc(func(int) time.Duration { return d })
+}
+
+// ExponentialBackoff waits base before the first retry, multiplying the delay by factor for every following one.
+func ExponentialBackoff(base time.Duration, factor float64) Backoff {
+    return BackoffFunc(func(attempt int) time.Duration {
+        return clampDuration(float64(base) * math.Pow(factor, float64(max(attempt, 1)-1)))
+    })
+}
+
+// FibonacciBackoff waits base times the Fibonacci number of the attempt, 1, 1, 2, 3, 5 and so on.
+func FibonacciBackoff(base time.Duration) Backoff {
+    return BackoffFunc(func(attempt int) time.Duration {
+        // The 100th Fibonacci number is far past the longest Duration.
+        a, b := 0.0, 1.0
+        for range min(max(attempt, 1), 100) {
+            a, b = b, a+b
+        }
+        return clampDuration(float64(base) * a)
+    })
+}
+
+// CappedBackoff limits the delays of b to limit.
+func CappedBackoff(b Backoff, limit time.Duration) Backoff {
+    return BackoffFunc(func(attempt int) time.Duration {
+        return min(b.Delay(attempt), limit)
+    })
+}
+
+// JitteredBackoff randomizes the delays of b by up to fraction of the delay in either direction,
+// so commands failing together do not retry together. A fraction of 1 waits anywhere between 0 and twice the delay.
+func JitteredBackoff(b Backoff, fraction float64) Backoff {
+    return BackoffFunc(func(attempt int) time.Duration {
+        d := float64(b.Delay(attempt))
+        return clampDuration(d + d*fraction*(2*rand.Float64()-1))
+    })
+}
+
+// clampDuration converts d to a Duration between 0 and the longest Duration.
+func clampDuration(d float64) time.Duration {
+    if d <= 0 || math.IsNaN(d) {
+        return 0
+    } else if d >= math.MaxInt64 {
+        return math.MaxInt64
+    }
+    return time.Duration(d)
+}
@@ -0,0 +1,109 @@
+package subflow
+
+import (
+    "bufio"
+    "bytes"
+    "errors"
+    "os"
+    "path/filepath"
+    "strconv"
+    "time"
+)
+
+// ErrNoCgroup is returned when a cgroup operation is used on a command not started with WithCgroup.
+var ErrNoCgroup = errors.New("command has no cgroup")
+
+// Freeze freezes the whole process tree of the command using the cgroup v2 freezer,
+// including children that ignore or block signals. It returns once the tree is frozen.
+// The command must have been created with WithCgroup, it returns ErrNotStarted if the command was not started
+// and ErrClosed if it was closed or has exited.
+func (cmd *Cmd) Freeze() error {
+    if err := cmd.setFrozen(true); err != nil {
+        return err
+    }
+    cmd.out.Push(NewFrozenMessage())
+    return nil
+}
+
+// Thaw resumes a process tree frozen by Freeze, it returns the same errors as Freeze.
+func (cmd *Cmd) Thaw() error {
+    if err := cmd.setFrozen(false); err != nil {
+        return err
+    }
+    cmd.out.Push(NewThawedMessage())
+    return nil
+}
+
+func (cmd *Cmd) setFrozen(frozen bool) error {
+    if _, err := cmd.process(); err != nil {
+        return err
+    } else if cmd.res.cgroup == "" {
+        return ErrNoCgroup
+    }
+    state := []byte("0")
+    if frozen {
+        state = []byte("1")
+    }
+    if err := os.WriteFile(filepath.Join(cmd.res.cgroup, "cgroup.freeze"), state, 0); err != nil {
+        return err
+    }
+
+    // The freezer changes state asynchronously, wait until cgroup.events reports it.
+    for {
+        if ok, err := cgroupEvent(cmd.res.cgroup, "frozen", state); err != nil || ok {
+            return err
+        }
+        select {
+        case <-cmd.Done():
+            return ErrClosed
+        case <-time.After(time.Millisecond):
+        }
+    }
+}
+
+// cgroupEvent reports whether key of cgroup.events in the cgroup dir has value.
+func cgroupEvent(dir, key string, value []byte) (bool, error) {
+    b, err := os.ReadFile(filepath.Join(dir, "cgroup.events"))
+    if err != nil {
+        return false, err
+    }
+    for s := bufio.NewScanner(bytes.NewReader(b)); s.Scan(); {
+        if k, v, _ := bytes.Cut(s.Bytes(), []byte(" ")); string(k) == key {
+            return bytes.Equal(v, value), nil
+        }
+    }
+    return false, nil
+}
+
+// reportCgroupUsage emits a CgroupUsageMessage every interval of WithCgroupUsage until stop is called.
+func (cmd *Cmd) reportCgroupUsage() (stop func()) {
+    if cmd.res.cgroup == "" {
+        return func() {}
+    }
+    return cmd.report(cmd.opts.cgroupUsage, func() Message { return readCgroupUsage(cmd.res.cgroup) })
+}
+
+// readCgroupUsage returns the usage of the cgroup dir, the usage of a missing controller is left 0.
+func readCgroupUsage(dir string) Message {
+    memory, _ := readCgroupInt(filepath.Join(dir, "memory.current"))
+    pids, _ := readCgroupInt(filepath.Join(dir, "pids.current"))
+    var cpu int64
+    if b, err := os.ReadFile(filepath.Join(dir, "cpu.stat")); err == nil {
+        for s := bufio.NewScanner(bytes.NewReader(b)); s.Scan(); {
+            if k, v, _ := bytes.Cut(s.Bytes(), []byte(" ")); string(k) == "usage_usec" {
+                cpu, _ = strconv.ParseInt(string(v), 10, 64)
+                break
+            }
+        }
+    }
+    return NewCgroupUsageMessage(memory, time.Duration(cpu)*time.Microsecond, int(pids))
+}
+
+// readCgroupInt reads the single integer of the cgroup interface file name.
+func readCgroupInt(name string) (int64, error) {
+    b, err := os.ReadFile(name)
+    if err != nil {
+        return 0, err
+    }
+    return strconv.ParseInt(string(bytes.TrimSpace(b)), 10, 64)
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+
+package subflow
+
+import "io"
+
+// outputWriter returns w, code pages only exist on Windows.
+func (o *options) outputWriter(w io.Writer) io.Writer { return w }
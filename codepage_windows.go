@@ -0,0 +1,88 @@
+//go:build windows
+
+package subflow
+
+import (
+    "fmt"
+    "io"
+    "slices"
+    "unicode/utf16"
+    "unsafe"
+)
+
+const cpUTF8 = 65001
+
+// outputWriter wraps w to transcode the configured code page to UTF-8.
+func (o *options) outputWriter(w io.Writer) io.Writer {
+    if o.codePage == nil {
+        return w
+    }
+    cp := *o.codePage
+    if cp == 0 {
+        cp = consoleCodePage()
+    }
+    if cp == cpUTF8 {
+        return w
+    }
+    return &codePageWriter{w: w, cp: cp}
+}
+
+// consoleCodePage returns the output code page of the console, falling back to the OEM code page.
+func consoleCodePage() uint32 {
+    if cp, _, _ := procGetConsoleOutputCP.Call(); cp != 0 {
+        return uint32(cp)
+    }
+    cp, _, _ := procGetOEMCP.Call()
+    return uint32(cp)
+}
+
+type codePageWriter struct {
+    w  io.Writer
+    cp uint32
+    // lead holds the lead byte of a double byte character split across writes.
+    lead []byte
+}
+
+func (cw *codePageWriter) Write(b []byte) (int, error) {
+    buf := append(cw.lead, b...)
+    n := cw.complete(buf)
+    cw.lead = slices.Clone(buf[n:])
+    if n == 0 {
+        return len(b), nil
+    }
+
+    u, err := decodeCodePage(cw.cp, buf[:n])
+    if err != nil {
+        return 0, err
+    } else if _, err := cw.w.Write(u); err != nil {
+        return 0, err
+    }
+    return len(b), nil
+}
+
+// complete returns the length of b without a trailing incomplete double byte character.
+func (cw *codePageWriter) complete(b []byte) int {
+    for i := 0; i < len(b); i++ {
+        if r, _, _ := procIsDBCSLeadByteEx.Call(uintptr(cw.cp), uintptr(b[i])); r != 0 {
+            if i+1 == len(b) {
+                return i
+            }
+            i++
+        }
+    }
+    return len(b)
+}
+
+// decodeCodePage converts b from the code page cp to UTF-8.
+func decodeCodePage(cp uint32, b []byte) ([]byte, error) {
+    n, _, err := procMultiByteToWideChar.Call(uintptr(cp), 0, uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)), 0, 0)
+    if n == 0 {
+        return nil, fmt.Errorf("decode code page %d: %w", cp, err)
+    }
+    wide := make([]uint16, n)
+    n, _, err = procMultiByteToWideChar.Call(uintptr(cp), 0, uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)), uintptr(unsafe.Pointer(&wide[0])), n)
+    if n == 0 {
+        return nil, fmt.Errorf("decode code page %d: %w", cp, err)
+    }
+    return []byte(string(utf16.Decode(wide[:n]))), nil
+}
@@ -1,6 +1,10 @@
 package subflow
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+	"os/exec"
+)
 
 type Command interface {
 	Command() string
@@ -21,10 +25,40 @@ type CommandArgsEnv interface {
 	Environment() []string
 }
 
+// CommandDir is a Command which runs in the working directory Dir.
+// An empty Dir runs it in the working directory of the current process.
+type CommandDir interface {
+	Command
+	Dir() string
+}
+
+// CommandStdin is a Command whose stdin is read from Stdin, see WithStdinReader.
+type CommandStdin interface {
+	Command
+	Stdin() io.Reader
+}
+
+// CommandUser is a Command which runs as User, a username or numeric user id.
+// It is only supported on Unix and usually requires root.
+type CommandUser interface {
+	Command
+	User() string
+}
+
+// CommandEnvPolicy is a Command which only inherits the environment variables of the current process allowed by EnvPolicy.
+type CommandEnvPolicy interface {
+	Command
+	EnvPolicy() EnvPolicy
+}
+
 type basicCommandArgs struct {
 	command string
 	args    []string
 	env     []string
+	dir     string
+	stdin   io.Reader
+	user    string
+	policy  *EnvPolicy
 }
 
 func NewCommand(command string) Command {
@@ -55,30 +89,137 @@ func NewCommandArgsEnv(command string, args, env []string) CommandArgsEnv {
 	}
 }
 
-// WithEnv appends new environment variables to the command.
-func WithEnv(cmd Command, env []string) CommandEnv {
-	command, args, subEnv := commandCollect(cmd)
+// NewCommandEnvMap returns a Command with the environment variables of env, see WithEnvMap.
+func NewCommandEnvMap(command string, env map[string]string) CommandEnv {
 	return &basicCommandArgs{
 		command: command,
-		args:    args,
-		env:     append(subEnv, env...),
+		env:     envSlice(env),
 	}
 }
 
-func commandCollect(cmd Command) (command string, args, env []string) {
-	command = cmd.Command()
+func NewCommandDir(command, dir string) CommandDir {
+	return &basicCommandArgs{
+		command: command,
+		dir:     dir,
+	}
+}
+
+// WithEnv appends new environment variables to the command.
+func WithEnv(cmd Command, env []string) CommandEnv {
+	spec := resolveCommand(cmd)
+	spec.env = append(spec.env[:len(spec.env):len(spec.env)], env...)
+	return spec.asCommand()
+}
+
+// WithEnvMap adds the environment variables of env to the command.
+// Variables of the command and the current process are overridden by the variables set later, compared case-insensitively on Windows.
+func WithEnvMap(cmd Command, env map[string]string) CommandEnv {
+	return WithEnv(cmd, envSlice(env))
+}
+
+// WithDir runs the command in the working directory dir instead of the working directory of the current process.
+func WithDir(cmd Command, dir string) CommandDir {
+	spec := resolveCommand(cmd)
+	spec.dir = dir
+	return spec.asCommand()
+}
+
+// WithEnvPolicy sets which environment variables of the current process are inherited by the command,
+// overriding the policy set with SetDefaultEnvPolicy.
+func WithEnvPolicy(cmd Command, policy EnvPolicy) CommandEnvPolicy {
+	spec := resolveCommand(cmd)
+	spec.policy = &policy
+	return spec.asCommand()
+}
+
+// WithLocale sets the locale of the command, e.g. "C.UTF-8", so its output does not depend on the parent's language settings.
+func WithLocale(cmd Command, locale string) CommandEnv {
+	return WithEnv(cmd, []string{"LANG=" + locale, "LC_ALL=" + locale})
+}
+
+// WithTimezone sets the timezone of the command, e.g. "UTC", so timestamps in its output are predictable.
+func WithTimezone(cmd Command, tz string) CommandEnv {
+	return WithEnv(cmd, []string{"TZ=" + tz})
+}
+
+// commandSpec is everything a Command and the optional interfaces it implements describe about how to run it.
+type commandSpec basicCommandArgs
+
+// resolveCommand collects the command and its metadata from cmd.
+func resolveCommand(cmd Command) commandSpec {
+	if cmd, ok := cmd.(*basicCommandArgs); ok {
+		// Keeps an unset policy unset.
+		return commandSpec(*cmd)
+	}
+	spec := commandSpec{command: cmd.Command()}
 	if cmd, ok := cmd.(CommandArgs); ok {
-		args = cmd.Args()
+		spec.args = cmd.Args()
 	}
 	if cmd, ok := cmd.(CommandEnv); ok {
-		env = cmd.Environment()
+		spec.env = cmd.Environment()
+	}
+	if cmd, ok := cmd.(CommandDir); ok {
+		spec.dir = cmd.Dir()
+	}
+	if cmd, ok := cmd.(CommandStdin); ok {
+		spec.stdin = cmd.Stdin()
+	}
+	if cmd, ok := cmd.(CommandUser); ok {
+		spec.user = cmd.User()
+	}
+	if cmd, ok := cmd.(CommandEnvPolicy); ok {
+		policy := cmd.EnvPolicy()
+		spec.policy = &policy
 	}
-	return
+	return spec
+}
+
+// asCommand returns a Command with the same metadata as spec.
+func (spec commandSpec) asCommand() *basicCommandArgs {
+	cmd := basicCommandArgs(spec)
+	return &cmd
+}
+
+// configure sets up c to run as described by spec.
+// The environment of c is the environment of the current process allowed by the policy, overridden by the environment of spec.
+func (spec *commandSpec) configure(c *exec.Cmd) error {
+	policy := spec.policy
+	if policy == nil {
+		policy = defaultEnvPolicy.Load()
+	}
+	env, err := policy.inherit()
+	if err != nil {
+		return err
+	}
+	c.Env = mergeEnv(env, spec.env)
+	c.Dir = spec.dir
+	if spec.user != "" {
+		return setUser(c, spec.user)
+	}
+	return nil
+}
+
+// options returns opts preceded by the options described by spec.
+func (spec *commandSpec) options(opts []Option) []Option {
+	if spec.stdin == nil {
+		return opts
+	}
+	return append([]Option{WithStdinReader(spec.stdin)}, opts...)
 }
 
 func (cmd *basicCommandArgs) Command() string       { return cmd.command }
 func (cmd *basicCommandArgs) Args() []string        { return cmd.args }
 func (cmd *basicCommandArgs) Environment() []string { return cmd.env }
+func (cmd *basicCommandArgs) Dir() string           { return cmd.dir }
+func (cmd *basicCommandArgs) Stdin() io.Reader      { return cmd.stdin }
+func (cmd *basicCommandArgs) User() string          { return cmd.user }
+
+func (cmd *basicCommandArgs) EnvPolicy() EnvPolicy {
+	if cmd.policy == nil {
+		return EnvPolicy{}
+	}
+	return *cmd.policy
+}
 
 // ErrExitCode represents a non zero process exit code.
 type ErrExitCode int
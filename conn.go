@@ -0,0 +1,138 @@
+package subflow
+
+import (
+    "context"
+    "fmt"
+    "net"
+    "os"
+    "sync"
+    "time"
+)
+
+var _ net.Conn = (*conn)(nil)
+
+// conn adapts the stdio of a command to a net.Conn.
+type conn struct {
+    cmd    *Cmd
+    reader stdoutReader
+    cancel context.CancelFunc
+
+    readDeadline  deadline
+    writeDeadline deadline
+}
+
+// NewConn returns a net.Conn over the stdio of cmd, reads return its stdout and writes are pushed to its stdin.
+// The command is started if it has not been already, output emitted before NewConn is called is not returned.
+// Closing the connection closes the command.
+func NewConn(cmd *Cmd) net.Conn {
+    ctx, cancel := context.WithCancel(context.Background())
+    c := &conn{
+        cmd:    cmd,
+        reader: stdoutReader{out: cmd.Listen(ctx)},
+        cancel: cancel,
+    }
+    cmd.Start()
+    return c
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+    return c.reader.read(b, c.readDeadline.wait())
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+    select {
+    case <-c.writeDeadline.wait():
+        return 0, os.ErrDeadlineExceeded
+    default:
+    }
+    n, err := c.cmd.Write(b)
+    if err != nil {
+        err = fmt.Errorf("%w: %w", net.ErrClosed, err)
+    }
+    return n, err
+}
+
+func (c *conn) Close() error {
+    defer c.cancel()
+    return c.cmd.Close()
+}
+
+func (c *conn) LocalAddr() net.Addr  { return stdioAddr("stdio") }
+func (c *conn) RemoteAddr() net.Addr { return stdioAddr(c.cmd.cmd.Path) }
+
+func (c *conn) SetDeadline(t time.Time) error {
+    c.readDeadline.set(t)
+    c.writeDeadline.set(t)
+    return nil
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+    c.readDeadline.set(t)
+    return nil
+}
+
+func (c *conn) SetWriteDeadline(t time.Time) error {
+    c.writeDeadline.set(t)
+    return nil
+}
+
+// stdioAddr is the address of either end of a connection over stdio.
+type stdioAddr string
+
+func (stdioAddr) Network() string  { return "stdio" }
+func (a stdioAddr) String() string { return string(a) }
+
+// deadline provides a channel which closes once the deadline has passed.
+// The channel is only replaced once it has closed, so readers blocked on it see later changes.
+type deadline struct {
+    lock  sync.Mutex
+    timer *time.Timer
+    done  chan struct{}
+}
+
+// set changes the deadline to t, a zero t means no deadline.
+func (d *deadline) set(t time.Time) {
+    d.lock.Lock()
+    defer d.lock.Unlock()
+
+    if d.timer != nil && !d.timer.Stop() {
+        // The timer already fired, wait for it to close the channel.
+        <-d.done
+    }
+    d.timer = nil
+    closed := d.closed()
+    if t.IsZero() {
+        if closed {
+            d.done = make(chan struct{})
+        }
+    } else if dur := time.Until(t); dur > 0 {
+        if closed {
+            d.done = make(chan struct{})
+        }
+        done := d.done
+        d.timer = time.AfterFunc(dur, func() { close(done) })
+    } else if !closed {
+        close(d.done)
+    }
+}
+
+// closed reports whether the current channel has closed, allocating it if needed.
+func (d *deadline) closed() bool {
+    if d.done == nil {
+        d.done = make(chan struct{})
+    }
+    select {
+    case <-d.done:
+        return true
+    default:
+        return false
+    }
+}
+
+// wait returns a channel which closes once the deadline has passed.
+func (d *deadline) wait() <-chan struct{} {
+    d.lock.Lock()
+    defer d.lock.Unlock()
+    d.closed()
+    return d.done
+}
@@ -0,0 +1,67 @@
+package subflow
+
+import (
+    "context"
+    "errors"
+    "os"
+    "runtime"
+    "testing"
+    "time"
+)
+
+func TestConnReadDeadlineWakesBlockedRead(t *testing.T) {
+    if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+        t.Skip("needs cat")
+    }
+    cmd, err := New(context.Background(), NewCommand("cat"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    c := NewConn(cmd)
+    defer c.Close()
+
+    read := make(chan error, 1)
+    go func() {
+        _, err := c.Read(make([]byte, 1))
+        read <- err
+    }()
+    // Give the read time to block on the deadline channel.
+    time.Sleep(50 * time.Millisecond)
+    if err := c.SetReadDeadline(time.Now()); err != nil {
+        t.Fatal(err)
+    }
+
+    select {
+    case err := <-read:
+        if !errors.Is(err, os.ErrDeadlineExceeded) {
+            t.Errorf("read error = %v, want %v", err, os.ErrDeadlineExceeded)
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatal("read was not woken by the deadline")
+    }
+}
+
+func TestConnReadDeadlineExtends(t *testing.T) {
+    if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+        t.Skip("needs cat")
+    }
+    cmd, err := New(context.Background(), NewCommand("cat"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    c := NewConn(cmd)
+    defer c.Close()
+
+    c.SetReadDeadline(time.Now())
+    if _, err := c.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+        t.Fatalf("read error = %v, want %v", err, os.ErrDeadlineExceeded)
+    }
+    c.SetReadDeadline(time.Time{})
+    if _, err := c.Write([]byte("x")); err != nil {
+        t.Fatal(err)
+    }
+    b := make([]byte, 1)
+    if _, err := c.Read(b); err != nil || string(b) != "x" {
+        t.Errorf("read %q, %v after clearing the deadline", b, err)
+    }
+}
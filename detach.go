@@ -0,0 +1,109 @@
+package subflow
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "os/exec"
+    "time"
+)
+
+// Detached is the handle of a process started with StartDetached.
+// It is saved as JSON, so the process can be re-attached with AttachDetached from another process.
+type Detached struct {
+    Pid     int       `json:"pid"`
+    Command string    `json:"command"`
+    Args    []string  `json:"args"`
+    Stdout  string    `json:"stdout"`
+    Stderr  string    `json:"stderr"`
+    Started time.Time `json:"started"`
+}
+
+// StartDetached starts cmd as a daemon which survives the exit of the current process,
+// in a new session on Unix and without a console on Windows. It does not use pipes,
+// its stdin is the null device and its stdout and stderr are appended to the files handle.stdout and handle.stderr.
+// The Detached handle is written to the file handle.
+// Options allocating resources for the lifetime of the process, such as WithWorkspace or WithCgroup, are not supported.
+// The process is not waited for, so attaching to it from the current process never races another waiter.
+// If it exits before the current process it stays a zombie until then, which Attach reports as exited on Linux.
+// It is only supported on Unix and Windows.
+func StartDetached(cmd Command, handle string, opts ...Option) (Detached, error) {
+    spec := resolveCommand(cmd)
+    o := newOptions(opts)
+    c := exec.Command(spec.command, spec.args...)
+    if err := spec.configure(c); err != nil {
+        return Detached{}, err
+    } else if spec.stdin != nil || len(o.stdinReaders) > 0 {
+        return Detached{}, errors.New("detached process can not read stdin")
+    }
+
+    d := Detached{
+        Command: spec.command,
+        Args:    spec.args,
+        Stdout:  handle + ".stdout",
+        Stderr:  handle + ".stderr",
+    }
+    stdout, err := os.OpenFile(d.Stdout, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+    if err != nil {
+        return Detached{}, err
+    }
+    defer stdout.Close()
+    stderr, err := os.OpenFile(d.Stderr, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+    if err != nil {
+        return Detached{}, err
+    }
+    defer stderr.Close()
+    c.Stdout, c.Stderr = stdout, stderr
+
+    res, err := o.apply(c)
+    if err != nil {
+        return Detached{}, err
+    } else if len(res.cleanup) > 0 || len(res.drain) > 0 || res.stdin != nil {
+        return Detached{}, errors.Join(errors.New("options with resources are not supported for a detached process"), res.release())
+    } else if err := detach(c); err != nil {
+        return Detached{}, err
+    } else if err := o.launch(c); err != nil {
+        return Detached{}, err
+    }
+    d.Pid, d.Started = c.Process.Pid, time.Now()
+
+    b, err := json.Marshal(d)
+    if err == nil {
+        err = os.WriteFile(handle, b, 0o600)
+    }
+    if err != nil {
+        return d, fmt.Errorf("write handle: %w", err)
+    }
+    return d, nil
+}
+
+// ReadDetached reads the Detached handle from the file handle.
+func ReadDetached(handle string) (Detached, error) {
+    var d Detached
+    b, err := os.ReadFile(handle)
+    if err != nil {
+        return d, err
+    }
+    return d, json.Unmarshal(b, &d)
+}
+
+// AttachDetached re-attaches to the process of the handle file written by StartDetached, following its stdout and stderr files.
+// Like FollowStdout only output written after the call is emitted, the exit code is -1 if it can not be known.
+func AttachDetached(ctx context.Context, handle string) (*Process, error) {
+    d, err := ReadDetached(handle)
+    if err != nil {
+        return nil, err
+    }
+    p, err := Attach(ctx, d.Pid)
+    if err != nil {
+        return nil, err
+    }
+    if err := p.FollowStdout(d.Stdout); err != nil {
+        return nil, errors.Join(err, p.Close())
+    } else if err := p.FollowStderr(d.Stderr); err != nil {
+        return nil, errors.Join(err, p.Close())
+    }
+    return p, nil
+}
@@ -0,0 +1,8 @@
+//go:build !unix && !windows
+
+package subflow
+
+import "os/exec"
+
+// detach starts c detached from the current process, which is not supported.
+func detach(*exec.Cmd) error { return unsupported("detached process") }
@@ -0,0 +1,13 @@
+//go:build unix
+
+package subflow
+
+import "os/exec"
+
+// detach starts c in a new session, so it has no controlling terminal and is not signalled with the process group of the current process.
+func detach(c *exec.Cmd) error {
+    attr := sysProcAttr(c)
+    // A new session always gets a new process group, setting one too fails.
+    attr.Setsid, attr.Setpgid = true, false
+    return nil
+}
@@ -0,0 +1,16 @@
+//go:build windows
+
+package subflow
+
+import (
+    "os/exec"
+    "syscall"
+)
+
+const detachedProcess = 0x00000008
+
+// detach starts c without a console and in a new process group, so it does not receive the console signals of the current process.
+func detach(c *exec.Cmd) error {
+    sysProcAttr(c).CreationFlags |= detachedProcess | syscall.CREATE_NEW_PROCESS_GROUP
+    return nil
+}
@@ -0,0 +1,87 @@
+package subflow
+
+import (
+    "cmp"
+    "context"
+    "errors"
+    "time"
+)
+
+// drainPoll is how often Drain checks whether the command is idle.
+const drainPoll = 10 * time.Millisecond
+
+// defaultDrainGrace is how long Drain waits for the command to exit after closing its stdin, if the policy sets no Grace.
+const defaultDrainGrace = 5 * time.Second
+
+// DrainPolicy decides when a draining command has finished its in-flight work.
+// The input queue is always waited on before the policy is applied.
+type DrainPolicy struct {
+    // Quiet is how long the command must be silent, neither reading input nor producing output.
+    Quiet time.Duration
+    // Probe, if set, is used instead of Quiet and returns nil once the in-flight work is done.
+    Probe func(context.Context, *Cmd) error
+    // Grace is how long the command gets to exit after its stdin was closed before it is killed, 5 seconds if zero.
+    Grace time.Duration
+}
+
+// Drain stops accepting input and waits for the in-flight work of the command as decided by policy.
+// It then closes the stdin of the process, so it receives EOF, and waits up to policy.Grace for it to exit before it is closed.
+// If ctx is done first, the command is closed right away and the context error is returned along with the error of Close.
+func (cmd *Cmd) Drain(ctx context.Context, policy DrainPolicy) error {
+    cmd.draining.Store(true)
+    if err := cmd.waitDrained(ctx, policy); err != nil {
+        return errors.Join(err, cmd.Close())
+    }
+
+    cmd.closeDrainedStdin()
+    select {
+    case <-cmd.Done():
+    case <-time.After(cmp.Or(policy.Grace, defaultDrainGrace)):
+    case <-ctx.Done():
+        return errors.Join(ctx.Err(), cmd.Close())
+    }
+    return cmd.Close()
+}
+
+// closeDrainedStdin queues EOFInput for a draining command, which no longer accepts pushes, unless its stdin was closed before.
+func (cmd *Cmd) closeDrainedStdin() {
+    cmd.pushLock.Lock()
+    defer cmd.pushLock.Unlock()
+    if !cmd.stdinClosed {
+        cmd.push([]Input{EOFInput})
+        cmd.stdinClosed = true
+    }
+}
+
+func (cmd *Cmd) waitDrained(ctx context.Context, policy DrainPolicy) error {
+    quiet := policy.Quiet
+    if policy.Probe != nil {
+        quiet = 0
+    }
+
+    for {
+        idle := time.Since(time.Unix(0, cmd.activity.Load()))
+        if cmd.pending.Load() == 0 && idle >= quiet {
+            break
+        }
+        wait := drainPoll
+        if cmd.pending.Load() == 0 {
+            wait = quiet - idle
+        }
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-cmd.Done():
+            return nil
+        case <-time.After(wait):
+        }
+    }
+
+    if policy.Probe != nil {
+        // A command exiting on its own has finished its work.
+        if err := waitReady(ctx, cmd, policy.Probe); !errors.Is(err, ErrNotReady) {
+            return err
+        }
+    }
+    return nil
+}
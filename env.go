@@ -0,0 +1,127 @@
+package subflow
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "fmt"
+    "maps"
+    "os"
+    "path"
+    "runtime"
+    "slices"
+    "strings"
+    "sync/atomic"
+)
+
+// EnvPolicy decides which environment variables of the current process are inherited by a command.
+// Patterns match variable names with the syntax of path.Match, e.g. "AWS_*", case-insensitively on Windows.
+// The zero EnvPolicy inherits every variable.
+type EnvPolicy struct {
+    // Allow, if not empty, only inherits the variables matching one of the patterns.
+    Allow []string
+    // Deny never inherits the variables matching one of the patterns, even if they are allowed.
+    Deny []string
+}
+
+var defaultEnvPolicy atomic.Pointer[EnvPolicy]
+
+// SetDefaultEnvPolicy sets the policy of every command without its own policy set with WithEnvPolicy.
+func SetDefaultEnvPolicy(policy EnvPolicy) {
+    defaultEnvPolicy.Store(&policy)
+}
+
+// inherit returns the environment of the current process filtered by the policy.
+func (p *EnvPolicy) inherit() ([]string, error) {
+    if p == nil || (len(p.Allow) == 0 && len(p.Deny) == 0) {
+        return os.Environ(), nil
+    }
+
+    var env []string
+    for _, kv := range os.Environ() {
+        name := envName(kv)
+        allowed := len(p.Allow) == 0
+        if !allowed {
+            ok, err := matchEnv(p.Allow, name)
+            if err != nil {
+                return nil, err
+            }
+            allowed = ok
+        }
+        if denied, err := matchEnv(p.Deny, name); err != nil {
+            return nil, err
+        } else if allowed && !denied {
+            env = append(env, kv)
+        }
+    }
+    return env, nil
+}
+
+// envSlice returns the variables of env as "name=value" pairs sorted by name.
+func envSlice(env map[string]string) []string {
+    kv := make([]string, 0, len(env))
+    for _, name := range slices.Sorted(maps.Keys(env)) {
+        kv = append(kv, name+"="+env[name])
+    }
+    return kv
+}
+
+// mergeEnv returns the variables of env overridden by the variables of override, a later variable replaces an earlier one with the same name.
+// The position of a replaced variable is kept. On Windows names are compared case-insensitively.
+func mergeEnv(env, override []string) []string {
+    merged := make([]string, 0, len(env)+len(override))
+    index := make(map[string]int, len(env)+len(override))
+    for _, kv := range slices.Concat(env, override) {
+        name := envName(kv)
+        if runtime.GOOS == "windows" {
+            name = strings.ToUpper(name)
+        }
+        if i, ok := index[name]; ok {
+            merged[i] = kv
+            continue
+        }
+        index[name] = len(merged)
+        merged = append(merged, kv)
+    }
+    return merged
+}
+
+// envName returns the name of the variable kv.
+// On Windows the names of the per drive working directories start with '=', e.g. "=C:=C:\dir".
+func envName(kv string) string {
+    if strings.HasPrefix(kv, "=") {
+        name, _, _ := strings.Cut(kv[1:], "=")
+        return "=" + name
+    }
+    name, _, _ := strings.Cut(kv, "=")
+    return name
+}
+
+// matchEnv reports if name matches one of the patterns.
+func matchEnv(patterns []string, name string) (bool, error) {
+    if runtime.GOOS == "windows" {
+        name = strings.ToUpper(name)
+    }
+    for _, pattern := range patterns {
+        if runtime.GOOS == "windows" {
+            pattern = strings.ToUpper(pattern)
+        }
+        if ok, err := path.Match(pattern, name); err != nil {
+            return false, fmt.Errorf("env pattern %q: %w", pattern, err)
+        } else if ok {
+            return true, nil
+        }
+    }
+    return false, nil
+}
+
+// newRunID returns a random id for a run of WithRunEnv.
+func newRunID() string {
+    return randomHex(8)
+}
+
+// randomHex returns n random bytes encoded in hex.
+func randomHex(n int) string {
+    b := make([]byte, n)
+    _, _ = rand.Read(b)
+    return hex.EncodeToString(b)
+}
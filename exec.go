@@ -3,9 +3,11 @@ package subflow
 import (
     "context"
     "errors"
+    "fmt"
     "github.com/bobcatalyst/flow"
     "io"
     "log/slog"
+    "math"
     "os"
     "os/exec"
     "slices"
@@ -16,30 +18,104 @@ import (
 
 type Cmd struct {
     stdin io.WriteCloser
-    in    flow.Stream[Input]
-    out   flow.Stream[Message]
+    tee   *teeWriter
+    // writers mirror the output to the Stdout and Stderr already set on the wrapped exec.Cmd.
+    writers []*teeWriter
+    in      flow.Stream[Input]
+    inputs  <-chan Input
+    out     outStream
+
+    reader     stdoutReader
+    readerOnce sync.Once
+    sinks      sinkGroup
+
+    // pending and pendingBytes count the inputs pushed but not yet written to stdin.
+    pending      atomic.Int64
+    pendingBytes atomic.Int64
+    draining     atomic.Bool
+    // pushLock serializes pushes so the input limits are not exceeded by concurrent callers.
+    pushLock sync.Mutex
+    // pushedBytes is the size of all inputs pushed, counted against the quota of WithInputQuota under pushLock.
+    pushedBytes   int64
+    quotaExceeded bool
+    // stdinClosed is set under pushLock once EOFInput was pushed.
+    stdinClosed bool
+    // written is closed and replaced every time an input is written to stdin.
+    written     chan struct{}
+    writtenLock sync.Mutex
+    // activity is the time in unix nanoseconds of the last stdin write or output.
+    activity atomic.Int64
+    stats    runStats
+    meter    ioMeter
 
     cmd    *exec.Cmd
+    opts   *options
+    res    *resources
+    parent context.Context
     ctx    context.Context
     cancel context.CancelFunc
     stop   func() bool
+    // cause is the first recorded reason the command was stopped.
+    cause atomic.Pointer[exitCause]
+    // signals holds the signals sent with Signal.
+    signals sync.Map
+
+    // hookLock guards the hooks of OnBeforeStart and OnExit.
+    hookLock    sync.Mutex
+    beforeStart []func(*exec.Cmd) error
+    onExit      []func(ExitMessage)
 
-    started  atomic.Bool
+    started atomic.Bool
+    // launched is closed once the process was started or failed to start, with startErr set if it failed.
+    launched chan struct{}
+    startErr error
+    // exit is the final ExitMessage once it was emitted.
+    exit     atomic.Pointer[ExitMessage]
     wait     chan struct{}
     waitErr  error
     killOnce sync.Once
 }
 
-func New(ctx context.Context, cmd CommandArgs) (_ *Cmd, finalErr error) {
+// New creates a command described by cmd and the optional interfaces it implements, configured by opts.
+// What runs, its arguments, environment and working directory, is part of cmd, see WithEnv, WithEnvMap and WithDir,
+// so it is the same for every function taking a Command, like Run, RunGroup and Keepalive.
+func New(ctx context.Context, cmd Command, opts ...Option) (*Cmd, error) {
+    spec := resolveCommand(cmd)
+    c := exec.Command(spec.command, spec.args...)
+    if err := spec.configure(c); err != nil {
+        return nil, err
+    }
+    return Wrap(ctx, c, spec.options(opts)...)
+}
+
+// Wrap adopts a configured but not yet started exec.Cmd, layering the streaming and lifecycle of Cmd on top of it.
+// Stdout and Stderr already set on c keep receiving the output, Stdin must not be set.
+// If one of them fails it is reported with an ErrorMessage and receives no more output, the messages are not affected.
+// The process is killed when ctx is done or the Cmd is closed.
+func Wrap(ctx context.Context, cmd *exec.Cmd, opts ...Option) (_ *Cmd, finalErr error) {
+    if cmd.Process != nil {
+        return nil, errors.New("exec: already started")
+    }
     finally, cleanup := checkOk()
 
     // Setup command struct
+    parent := ctx
     ctx, cancel := context.WithCancel(ctx)
     defer cleanup(cancel)
     c := Cmd{
-        ctx:    ctx,
-        cancel: cancel,
-        wait:   make(chan struct{}),
+        opts:     newOptions(opts),
+        parent:   parent,
+        ctx:      ctx,
+        cancel:   cancel,
+        launched: make(chan struct{}),
+        wait:     make(chan struct{}),
+        written:  make(chan struct{}),
+    }
+    c.opts.writeError = func(source string, err error) { c.out.Push(NewErrorMessage(source, err)) }
+    c.out.limit = c.opts.history
+    c.out.compact = c.opts.compact
+    if c.opts.bufferedOutput && c.opts.history <= 0 {
+        c.out.limit = math.MaxInt
     }
 
     // Make command and setup io
@@ -48,10 +124,23 @@ func New(ctx context.Context, cmd CommandArgs) (_ *Cmd, finalErr error) {
         return nil, err
     }
     c.stdin = in
+    // Listen right away so inputs pushed before Start are queued.
+    c.inputs = c.in.Listen(ctx)
     defer cleanup(func() { finalErr = errors.Join(finalErr, c.Close()) })
+    for _, sink := range c.opts.sinks {
+        c.sinks.start(&c.out, sink, c.opts)
+    }
+    if len(c.opts.stdinReaders) > 0 {
+        go c.pushFrom(io.MultiReader(c.opts.stdinReaders...))
+    }
 
     // Make sure close is run at lease once if one of the goroutines cancels the context
-    c.stop = context.AfterFunc(ctx, func() { c.Close() })
+    c.stop = context.AfterFunc(ctx, func() {
+        if parent.Err() != nil {
+            c.setCause(contextCause(parent))
+        }
+        c.Close()
+    })
     defer cleanup(func() { c.stop() })
 
     finally()
@@ -69,11 +158,204 @@ func checkOk() (finally func(), cleanup func(func())) {
         }
 }
 
-// Push adds new inputs to the command's input stream
-func (cmd *Cmd) Push(in ...Input) { cmd.in.Push(in...) }
+var (
+    // ErrClosed is returned when pushing input to a command that was closed or has exited.
+    ErrClosed = errors.New("command closed")
+    // ErrDraining is returned when pushing input to a command that is draining.
+    ErrDraining = errors.New("command draining")
+    // ErrQueueFull is returned when pushing input that would exceed the input limits of a command.
+    ErrQueueFull = errors.New("input queue full")
+    // ErrNotStarted is returned when signalling a command that was not started.
+    ErrNotStarted = errors.New("command not started")
+    // ErrInputRejected is returned when pushing input rejected by a filter of WithInputFilter.
+    ErrInputRejected = errors.New("input rejected")
+    // ErrInputQuota is returned when pushing input that would exceed the quota set by WithInputQuota.
+    ErrInputQuota = errors.New("input quota exceeded")
+    // ErrStdinClosed is returned when pushing input after EOFInput, e.g. with CloseStdin.
+    ErrStdinClosed = errors.New("stdin closed")
+)
+
+// Push adds new inputs to the command's input stream.
+// Inputs pushed after the command was closed, exited or started draining, or exceeding its input limits, are dropped and a warning is logged,
+// use PushErr to handle it instead.
+func (cmd *Cmd) Push(in ...Input) {
+    if err := cmd.PushErr(in...); err != nil {
+        slog.Warn("dropped input", "count", len(in), "error", err)
+    }
+}
+
+// PushErr adds new inputs to the command's input stream.
+// It returns ErrClosed if the command was closed or has exited, ErrDraining if it is draining,
+// ErrQueueFull if the inputs would exceed the limits set by WithInputLimit,
+// ErrInputQuota if they would exceed the quota set by WithInputQuota,
+// ErrInputRejected if an input was rejected by a filter of WithInputFilter
+// and ErrStdinClosed if an input follows EOFInput.
+func (cmd *Cmd) PushErr(in ...Input) error {
+    in, err := cmd.filter(in)
+    if err != nil {
+        return err
+    }
+    cmd.pushLock.Lock()
+    defer cmd.pushLock.Unlock()
+    eof := slices.Index(in, EOFInput)
+    if err := cmd.pushable(); err != nil {
+        return err
+    } else if cmd.stdinClosed || eof >= 0 && eof < len(in)-1 {
+        return ErrStdinClosed
+    } else if err := cmd.withinQuota(in); err != nil {
+        return err
+    } else if !cmd.fits(in) {
+        return ErrQueueFull
+    }
+    cmd.push(in)
+    cmd.stdinClosed = eof >= 0
+    return nil
+}
+
+// CloseStdin closes the stdin of the process once the inputs pushed before were written, so it receives EOF,
+// without closing the command. It pushes EOFInput and returns the errors of PushErr.
+func (cmd *Cmd) CloseStdin() error {
+    return cmd.PushErr(EOFInput)
+}
+
+// withinQuota returns ErrInputQuota if in would exceed the quota of WithInputQuota, the queue must be locked.
+// Once the quota was exceeded every following push fails, the first time an InputQuotaMessage is emitted.
+func (cmd *Cmd) withinQuota(in []Input) error {
+    quota := cmd.opts.inputQuota
+    if quota <= 0 {
+        return nil
+    } else if !cmd.quotaExceeded {
+        size := cmd.pushedBytes + inputSize(in)
+        if size <= quota {
+            return nil
+        }
+        cmd.quotaExceeded = true
+        cmd.pushLaunched(NewInputQuotaMessage(quota, size))
+    }
+    return ErrInputQuota
+}
+
+// PushCtx adds new inputs to the command's input stream like PushErr,
+// but blocks while the inputs would exceed the limits set by WithInputLimit, until they fit or ctx is done.
+// Inputs larger than the limits are pushed once the queue is empty.
+func (cmd *Cmd) PushCtx(ctx context.Context, in ...Input) error {
+    for {
+        written := cmd.writtenSignal()
+        if err := cmd.PushErr(in...); !errors.Is(err, ErrQueueFull) {
+            return err
+        }
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-cmd.ctx.Done():
+        case <-cmd.Done():
+        case <-written:
+        }
+    }
+}
+
+// Queued returns the number of inputs and their size in bytes that were pushed but not yet written to stdin.
+func (cmd *Cmd) Queued() (inputs int, bytes int64) {
+    return int(cmd.pending.Load()), cmd.pendingBytes.Load()
+}
+
+// filter runs the filters of WithInputFilter on in, returning the inputs to queue.
+func (cmd *Cmd) filter(in []Input) ([]Input, error) {
+    if len(cmd.opts.inputFilters) == 0 {
+        return in, nil
+    }
+    in = slices.Clone(in)
+    for i := range in {
+        if _, secret := in[i].(secretInput); secret || in[i] == EOFInput {
+            continue
+        }
+        for _, filter := range cmd.opts.inputFilters {
+            filtered, err := filter(in[i])
+            if err != nil {
+                cmd.pushLaunched(NewInputRejectedMessage(in[i], err))
+                return nil, fmt.Errorf("%w: %w", ErrInputRejected, err)
+            }
+            in[i] = filtered
+        }
+    }
+    return in, nil
+}
+
+// pushLaunched pushes msg to the output once the process was started, so it follows the StartMessage.
+func (cmd *Cmd) pushLaunched(msg Message) {
+    select {
+    case <-cmd.launched:
+        cmd.out.Push(msg)
+    default:
+        go func() {
+            <-cmd.launched
+            cmd.out.Push(msg)
+        }()
+    }
+}
+
+// fits reports if in can be queued within the input limits, the queue must be locked.
+func (cmd *Cmd) fits(in []Input) bool {
+    inputs, bytes := cmd.Queued()
+    if inputs == 0 {
+        return true
+    }
+    limit, byteLimit := cmd.opts.inputLimit, cmd.opts.inputByteLimit
+    return (limit <= 0 || inputs+len(in) <= limit) && (byteLimit <= 0 || bytes+inputSize(in) <= byteLimit)
+}
+
+// push queues in, the queue must be locked.
+func (cmd *Cmd) push(in []Input) {
+    size := inputSize(in)
+    cmd.pushedBytes += size
+    cmd.pending.Add(int64(len(in)))
+    cmd.pendingBytes.Add(size)
+    cmd.in.Push(in...)
+}
+
+func inputSize(in []Input) (size int64) {
+    for _, in := range in {
+        size += int64(len(in.Input()))
+    }
+    return size
+}
+
+// pushable returns why inputs can not be pushed, if they can not.
+func (cmd *Cmd) pushable() error {
+    if cmd.draining.Load() {
+        return ErrDraining
+    } else if cmd.ctx.Err() != nil {
+        return ErrClosed
+    }
+    select {
+    case <-cmd.Done():
+        return ErrClosed
+    default:
+        return nil
+    }
+}
+
+// writtenSignal returns a channel which is closed once the next input is written to stdin.
+func (cmd *Cmd) writtenSignal() <-chan struct{} {
+    cmd.writtenLock.Lock()
+    defer cmd.writtenLock.Unlock()
+    return cmd.written
+}
+
+// inputWritten marks an input of size bytes as written to stdin.
+func (cmd *Cmd) inputWritten(size int) {
+    cmd.activity.Store(time.Now().UnixNano())
+    cmd.pending.Add(-1)
+    cmd.pendingBytes.Add(-int64(size))
+    cmd.writtenLock.Lock()
+    defer cmd.writtenLock.Unlock()
+    close(cmd.written)
+    cmd.written = make(chan struct{})
+}
 
 // Listen emits the process start, stdout/err/in, and the exit code.
-// It is non buffered, so any messages emitted before Listen is called will be lost.
+// It is non buffered, so any messages emitted before Listen is called will be lost,
+// unless they are kept by WithHistory and replayed with WithReplay, or the command was created with WithBufferedOutput.
 // Call Listen before Start to get all messages.
 //
 //	c1 := cmd.Listen(context.Background)
@@ -81,7 +363,28 @@ func (cmd *Cmd) Push(in ...Input) { cmd.in.Push(in...) }
 //	c2 := cmd.Listen(context.Background)
 //
 // c1 will contain the start message while c2 will not.
-func (cmd *Cmd) Listen(ctx context.Context) <-chan Message { return cmd.out.Listen(ctx) }
+// The opts change the view of this listener only.
+func (cmd *Cmd) Listen(ctx context.Context, opts ...ListenOption) <-chan Message {
+    if cmd.opts.bufferedOutput {
+        opts = append([]ListenOption{WithReplay(-1)}, opts...)
+    }
+    return cmd.out.view(ctx, opts)
+}
+
+// ListenResume returns a channel receiving every message after the one token was received with, each with the token to resume after it,
+// e.g. as the event id of server-sent events so a reconnecting consumer receives exactly the messages it missed.
+// An empty token starts with the messages emitted after the call, like Listen, tokens are only valid for the command which issued them.
+// The messages after the token must still be kept by WithHistory or WithBufferedOutput, otherwise ErrResumeExpired is returned.
+// A message merged by WithCompaction is resumed as a whole, its Offset tells which of its data was received before.
+func (cmd *Cmd) ListenResume(ctx context.Context, token string) (<-chan ResumableMessage, error) {
+    return cmd.out.listenResume(ctx, token)
+}
+
+// OutputBetween returns the messages kept by WithHistory or WithBufferedOutput which were emitted between from and to, inclusive,
+// e.g. what the command printed in the 30 seconds before it exited.
+func (cmd *Cmd) OutputBetween(from, to time.Time) []Message {
+    return cmd.out.between(from, to)
+}
 
 // Start starts the command exactly once.
 func (cmd *Cmd) Start() {
@@ -90,18 +393,126 @@ func (cmd *Cmd) Start() {
     }
 }
 
+// OnBeforeStart registers fn to be called with the underlying exec.Cmd right before the process is started,
+// e.g. to adjust its Dir or SysProcAttr. The hooks run in the order they were registered, those registered after Start are not run.
+// If a hook fails the process is not started and the command exits with ExitStartFailure.
+func (cmd *Cmd) OnBeforeStart(fn func(*exec.Cmd) error) {
+    cmd.hookLock.Lock()
+    defer cmd.hookLock.Unlock()
+    cmd.beforeStart = append(cmd.beforeStart, fn)
+}
+
+// OnExit registers fn to be called with the ExitMessage once the process has exited, before Done is closed.
+// The hooks run in the order they were registered and must not wait for the command, e.g. with Close.
+func (cmd *Cmd) OnExit(fn func(ExitMessage)) {
+    cmd.hookLock.Lock()
+    defer cmd.hookLock.Unlock()
+    cmd.onExit = append(cmd.onExit, fn)
+}
+
+func (cmd *Cmd) runBeforeStart() error {
+    cmd.hookLock.Lock()
+    hooks := slices.Clone(cmd.beforeStart)
+    cmd.hookLock.Unlock()
+    for _, fn := range hooks {
+        if err := fn(cmd.cmd); err != nil {
+            return fmt.Errorf("before start: %w", err)
+        }
+    }
+    return nil
+}
+
+func (cmd *Cmd) runOnExit(msg ExitMessage) {
+    cmd.hookLock.Lock()
+    hooks := slices.Clone(cmd.onExit)
+    cmd.hookLock.Unlock()
+    for _, fn := range hooks {
+        fn(msg)
+    }
+}
+
+// RunID returns the random id of the run set by WithRunEnv, or an empty string without it.
+func (cmd *Cmd) RunID() string {
+    return cmd.res.runID
+}
+
+// StartErr starts the command like Start and waits until the process was started,
+// returning why it could not be, e.g. a missing executable, or ErrClosed if the command was closed before.
+func (cmd *Cmd) StartErr() error {
+    cmd.Start()
+    <-cmd.launched
+    return cmd.startErr
+}
+
 // Done returns a channel that closes when the process completes.
 func (cmd *Cmd) Done() <-chan struct{} {
     return cmd.wait
 }
 
+// Wait waits for the command to complete and returns its error, like Close but without stopping it.
+// If ctx is done first the context error is returned and the command keeps running.
+func (cmd *Cmd) Wait(ctx context.Context) error {
+    select {
+    case <-ctx.Done():
+        return ctx.Err()
+    case <-cmd.Done():
+        return cmd.waitErr
+    }
+}
+
+// Err returns the error of the command once it has completed, and nil while it has not.
+func (cmd *Cmd) Err() error {
+    select {
+    case <-cmd.Done():
+        return cmd.waitErr
+    default:
+        return nil
+    }
+}
+
+// Pid returns the process id of the command, or 0 until its process was started.
+func (cmd *Cmd) Pid() int {
+    select {
+    case <-cmd.launched:
+        if cmd.cmd.Process != nil {
+            return cmd.cmd.Process.Pid
+        }
+    default:
+    }
+    return 0
+}
+
+// ProcessState returns the state of the exited process of the command, or nil until the command has completed.
+func (cmd *Cmd) ProcessState() *os.ProcessState {
+    select {
+    case <-cmd.Done():
+        return cmd.cmd.ProcessState
+    default:
+        return nil
+    }
+}
+
 // Close closes the Cmd waiting indefinitely for the subprocess to exit.
 func (cmd *Cmd) Close() error {
     return cmd.CloseTimeout(0)
 }
 
+// CloseContext closes the command like Close, waiting for it to exit and its sinks to be flushed until ctx is done.
+// If ctx is done first the command keeps shutting down in the background and the cause of ctx is returned.
+func (cmd *Cmd) CloseContext(ctx context.Context) error {
+    errc := make(chan error, 1)
+    go func() { errc <- cmd.Close() }()
+    select {
+    case err := <-errc:
+        return err
+    case <-ctx.Done():
+        return fmt.Errorf("close: %w", context.Cause(ctx))
+    }
+}
+
 // CloseTimeout stops the command and cleans up resources. If the command does not terminate, it will be killed after a timeout.
 func (cmd *Cmd) CloseTimeout(timeout time.Duration) error {
+    cmd.setCause(ExitCancelled, ActorCaller)
     cmd.cancel()
     cmd.stop()
     if cmd.started.CompareAndSwap(false, true) {
@@ -114,7 +525,7 @@ func (cmd *Cmd) CloseTimeout(timeout time.Duration) error {
                 select {
                 case <-cmd.Done():
                 case <-time.After(timeout):
-                    _ = cmd.cmd.Process.Signal(os.Kill)
+                    _ = cmd.opts.signal(cmd.cmd.Process, os.Kill)
                 }
             }
         })
@@ -123,15 +534,109 @@ func (cmd *Cmd) CloseTimeout(timeout time.Duration) error {
     return cmd.waitErr
 }
 
+// CloseGraceful closes the command like Close, but first sends term to the process, e.g. syscall.SIGTERM,
+// and gives it up to grace to exit on its own before it is killed.
+// If term can not be sent, e.g. on Windows, the process is killed right away.
+func (cmd *Cmd) CloseGraceful(term os.Signal, grace time.Duration) error {
+    cmd.setCause(ExitCancelled, ActorCaller)
+    if cmd.started.Load() && cmd.Signal(term) == nil {
+        select {
+        case <-cmd.Done():
+        case <-time.After(grace):
+        }
+    }
+    return cmd.Close()
+}
+
+// Signal sends sig to the process and emits a SignalMessage once it was sent.
+// It returns ErrNotStarted if the command was not started and ErrClosed if it was closed or has exited.
+// On Windows only os.Kill is supported.
+func (cmd *Cmd) Signal(sig os.Signal) error {
+    p, err := cmd.process()
+    if err != nil {
+        return err
+    }
+    cmd.signals.Store(sig, struct{}{})
+    if err := cmd.opts.signal(p, sig); errors.Is(err, os.ErrProcessDone) {
+        return ErrClosed
+    } else if err != nil {
+        return err
+    }
+    cmd.out.Push(NewSignalMessage(sig))
+    return nil
+}
+
+// Kill kills the process right away, without a grace period or waiting for it to exit, emitting a KillMessage right before.
+// The command exits with ExitKilled by ActorCaller.
+// It returns ErrNotStarted if the command was not started and ErrClosed if it was closed or has exited.
+func (cmd *Cmd) Kill() error {
+    p, err := cmd.process()
+    if err != nil {
+        return err
+    }
+    cmd.setCause(ExitKilled, ActorCaller)
+    cmd.out.Push(NewKillMessage())
+    if err := cmd.opts.signal(p, os.Kill); errors.Is(err, os.ErrProcessDone) {
+        return ErrClosed
+    } else if err != nil {
+        return err
+    }
+    return nil
+}
+
+// process returns the running process of the command.
+// It returns ErrNotStarted if the command was not started and ErrClosed if it was closed or has exited.
+func (cmd *Cmd) process() (*os.Process, error) {
+    if !cmd.started.Load() {
+        return nil, ErrNotStarted
+    }
+    <-cmd.launched
+    select {
+    case <-cmd.Done():
+        return nil, ErrClosed
+    default:
+    }
+    if cmd.cmd.Process == nil {
+        return nil, ErrClosed
+    }
+    return cmd.cmd.Process, nil
+}
+
 // runCmd starts and monitors the command, handling input and capturing output
 func (cmd *Cmd) runCmd() {
     defer cmd.cleanupCmd(true)
     setCode, sendCode := cmd.exitCode()
-    cmd.out.Push(NewStartMessage())
     defer sendCode()
 
-    go cmd.pipeInput(cmd.in.Listen(cmd.ctx), cmd.stdin)
-    if err := cmd.cmd.Run(); err != nil {
+    cmd.stats.started(time.Now())
+    err := cmd.runBeforeStart()
+    if err == nil {
+        err = cmd.opts.launch(cmd.cmd)
+    }
+    // The start message goes first, output and input wait for launched.
+    if err == nil {
+        msg := NewStartFilesMessage(cmd.cmd.Process.Pid, cmd.res.extraFds).(StartMessage)
+        msg.RunID = cmd.res.runID
+        cmd.out.Push(msg)
+    } else {
+        cmd.out.Push(NewStartMessage())
+    }
+    cmd.startErr = err
+    close(cmd.launched)
+    go cmd.pipeInput(cmd.inputs, cmd.stdin)
+    if err == nil {
+        // Kill the process once the command is closed.
+        stop := context.AfterFunc(cmd.ctx, func() { _ = cmd.opts.signal(cmd.cmd.Process, os.Kill) })
+        stopMonitors := cmd.startMonitors()
+        err = cmd.cmd.Wait()
+        stopMonitors()
+        stop()
+        cmd.waitErr = errors.Join(cmd.waitErr, cmd.res.drainOutput())
+        cmd.checkLimits()
+    } else {
+        cmd.setCause(ExitStartFailure, ActorSubflow)
+    }
+    if err != nil {
         setCode(-1)
         if exit := new(exec.ExitError); errors.As(err, &exit) {
             setCode(exit.ExitCode())
@@ -141,6 +646,89 @@ func (cmd *Cmd) runCmd() {
     }
 }
 
+// startMonitors starts the periodic reports and limits of the running process, stop waits until they have stopped.
+func (cmd *Cmd) startMonitors() (stop func()) {
+    stops := []func(){cmd.enforceDeadline(), cmd.enforceIdleTimeout(), cmd.reportCgroupUsage(), cmd.reportUsage(), cmd.reportMeter()}
+    return func() {
+        for _, stop := range stops {
+            stop()
+        }
+    }
+}
+
+// enforceDeadline closes the command once it ran longer than the deadline of WithDeadline, until stop is called.
+func (cmd *Cmd) enforceDeadline() (stop func()) {
+    d := cmd.opts.deadline
+    if d <= 0 {
+        return func() {}
+    }
+    done, stopped := make(chan struct{}), make(chan struct{})
+    go func() {
+        defer close(stopped)
+        t := time.NewTimer(d)
+        defer t.Stop()
+        select {
+        case <-done:
+        case <-t.C:
+            // A command already being closed keeps its cause.
+            if cmd.setCause(ExitDeadline, ActorSubflow) {
+                cmd.out.Push(NewTimeoutMessage(d))
+                cmd.cancel()
+            }
+        }
+    }()
+    return func() {
+        close(done)
+        <-stopped
+    }
+}
+
+// enforceIdleTimeout closes the command once it did not output anything for the timeout of WithIdleTimeout, until stop is called.
+func (cmd *Cmd) enforceIdleTimeout() (stop func()) {
+    d := cmd.opts.idleTimeout
+    if d <= 0 {
+        return func() {}
+    }
+    done, stopped := make(chan struct{}), make(chan struct{})
+    go func() {
+        defer close(stopped)
+        t := time.NewTimer(d)
+        defer t.Stop()
+        for {
+            select {
+            case <-done:
+                return
+            case <-t.C:
+            }
+            // Wait for the rest of the timeout if there was output in the meantime.
+            if idle := time.Since(cmd.stats.lastOutput()); idle < d {
+                t.Reset(d - idle)
+                continue
+            } else if cmd.setCause(ExitWatchdog, ActorSubflow) {
+                cmd.out.Push(NewIdleTimeoutMessage(idle))
+                cmd.cancel()
+            }
+            return
+        }
+    }()
+    return func() {
+        close(done)
+        <-stopped
+    }
+}
+
+// checkLimits reports if the process was killed by exceeding a resource limit.
+func (cmd *Cmd) checkLimits() {
+    resource, sig, ok := cmd.opts.limitExceeded(cmd.cmd.ProcessState)
+    if !ok {
+        return
+    } else if _, sent := cmd.signals.Load(sig); sent {
+        return
+    }
+    cmd.out.Push(NewLimitExceededMessage(resource, sig))
+    cmd.setCause(ExitLimit, ActorSubflow)
+}
+
 func (cmd *Cmd) exitCode() (setCode func(code int), sendCode func()) {
     var code int
     setCode = func(c int) {
@@ -150,70 +738,200 @@ func (cmd *Cmd) exitCode() (setCode func(code int), sendCode func()) {
         if code != 0 {
             cmd.waitErr = errors.Join(cmd.waitErr, ErrExitCode(code))
         }
-        cmd.out.Close(NewExitMessage(code))
+        reason, actor := cmd.exitCause()
+        msg := NewExitReasonMessage(code, reason, actor).(ExitMessage)
+        msg.Stats = cmd.stats.exit(time.Now())
+        cmd.exit.Store(&msg)
+        cmd.out.Close(msg)
+        cmd.runOnExit(msg)
     }
     return
 }
 
+type exitCause struct {
+    reason ExitReason
+    actor  ExitActor
+}
+
+// setCause records why the command is stopped, only the first cause is kept.
+// It reports if the cause was recorded.
+func (cmd *Cmd) setCause(reason ExitReason, actor ExitActor) bool {
+    return cmd.cause.CompareAndSwap(nil, &exitCause{reason: reason, actor: actor})
+}
+
+// contextCause returns the cause for the done context ctx.
+func contextCause(ctx context.Context) (ExitReason, ExitActor) {
+    if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+        return ExitDeadline, ActorContext
+    }
+    return ExitCancelled, ActorContext
+}
+
+// exitCause returns why the process ended.
+func (cmd *Cmd) exitCause() (ExitReason, ExitActor) {
+    if cause := cmd.cause.Load(); cause != nil {
+        return cause.reason, cause.actor
+    } else if cmd.parent.Err() != nil {
+        return contextCause(cmd.parent)
+    }
+    if state := cmd.cmd.ProcessState; state != nil {
+        if sig, ok := terminatingSignal(state); ok {
+            if _, sent := cmd.signals.Load(sig); sent {
+                return ExitSignal, ActorCaller
+            }
+            return ExitSignal, ActorProcess
+        }
+    }
+    return ExitNormal, ActorProcess
+}
+
 func (cmd *Cmd) cleanupCmd(started bool) {
     defer close(cmd.wait)
     if !started {
+        cmd.startErr = ErrClosed
+        close(cmd.launched)
         cmd.out.Close()
     }
-    // cmd.stdin will not be nil
-    cmd.waitErr = errors.Join(cmd.waitErr, cmd.stdin.Close())
+    // cmd.stdin and cmd.res will not be nil
+    cmd.waitErr = errors.Join(cmd.waitErr, closeStdin(cmd.stdin), cmd.res.release(), cmd.tee.Err(), cmd.writersErr(), cmd.sinks.wait(cmd.opts.flushTimeout))
 }
 
-func (cmd *Cmd) initializeCommand(cae Command) (stdin io.WriteCloser, _ error) {
-    command, args, env := commandCollect(cae)
-    cmd.cmd = exec.CommandContext(cmd.ctx, command, args...)
-    if len(cmd.cmd.Env) == 0 {
-        cmd.cmd.Env = os.Environ()
+// writersErr returns the errors of the writers mirroring the output.
+func (cmd *Cmd) writersErr() (err error) {
+    for _, tw := range cmd.writers {
+        err = errors.Join(err, tw.Err())
     }
-    cmd.cmd.Env = append(cmd.cmd.Env, env...)
-    cmd.cmd.Stdout, cmd.cmd.Stderr = cmd.newKindWriters()
-    return cmd.cmd.StdinPipe()
+    return err
+}
+
+// closeStdin closes the stdin of a command, which may already have been closed by pipeInput or exec.Cmd.Wait.
+func closeStdin(stdin io.Closer) error {
+    if err := stdin.Close(); !errors.Is(err, os.ErrClosed) {
+        return err
+    }
+    return nil
+}
+
+func (cmd *Cmd) initializeCommand(c *exec.Cmd) (stdin io.WriteCloser, _ error) {
+    cmd.cmd = c
+    stdout, stderr := cmd.newKindWriters()
+    cmd.cmd.Stdout = cmd.opts.outputWriter(cmd.teeOutput("stdout", c.Stdout, stdout))
+    cmd.cmd.Stderr = cmd.opts.outputWriter(cmd.teeOutput("stderr", c.Stderr, stderr))
+    res, err := cmd.opts.apply(cmd.cmd)
+    if err != nil {
+        return nil, err
+    }
+    cmd.res = res
+    cmd.tee = cmd.opts.tee()
+    if res.stdin != nil {
+        return res.stdin, nil
+    } else if stdin, err = cmd.cmd.StdinPipe(); err != nil {
+        return nil, errors.Join(err, res.release())
+    }
+    return stdin, nil
+}
+
+// teeOutput writes to both the existing writer and w, if there is an existing writer.
+// An error of the existing writer is kept for the result of the command instead of stopping the output.
+func (cmd *Cmd) teeOutput(source string, existing, w io.Writer) io.Writer {
+    if existing == nil {
+        return w
+    }
+    tw := cmd.opts.newTeeWriter(source, existing)
+    cmd.writers = append(cmd.writers, tw)
+    return io.MultiWriter(tw, w)
 }
 
 func (cmd *Cmd) newKindWriters() (*kindWriter[StdoutMessage], *kindWriter[StderrMessage]) {
     return &kindWriter[StdoutMessage]{
-            out: &cmd.out,
-            ctx: cmd.ctx,
-        }, &kindWriter[StderrMessage]{
-            out: &cmd.out,
-            ctx: cmd.ctx,
-        }
+        out:         &cmd.out,
+        ctx:         cmd.ctx,
+        activity:    &cmd.activity,
+        stats:       &cmd.stats,
+        bytes:       &cmd.meter.stdout,
+        scan:        cmd.scanner("stdout"),
+        firstOutput: cmd.opts.firstOutput,
+        launched:    cmd.launched,
+    }, &kindWriter[StderrMessage]{
+        out:         &cmd.out,
+        ctx:         cmd.ctx,
+        activity:    &cmd.activity,
+        stats:       &cmd.stats,
+        bytes:       &cmd.meter.stderr,
+        scan:        cmd.scanner("stderr"),
+        firstOutput: cmd.opts.firstOutput,
+        launched:    cmd.launched,
+    }
 }
 
 type kindWriter[K StdioLike] struct {
-    out flow.Pushable[Message]
-    ctx context.Context
+    out      flow.Pushable[Message]
+    ctx      context.Context
+    activity *atomic.Int64
+    stats    *runStats
+    bytes    *atomic.Int64
+    // scan, if set, looks for fatal patterns and prompts in the output after it was pushed.
+    scan        func([]byte)
+    firstOutput bool
+    launched    <-chan struct{}
 }
 
 func (kw *kindWriter[K]) Write(b []byte) (n int, _ error) {
+    <-kw.launched
     if kw.ctx.Err() != nil {
         return 0, kw.ctx.Err()
     }
-    kw.out.Push(NewStdioMessage[K](slices.Clone(b)))
+    now := time.Now()
+    kw.activity.Store(now.UnixNano())
+    offset := kw.bytes.Add(int64(len(b))) - int64(len(b))
+    if first, latency := kw.stats.output(now); first && kw.firstOutput {
+        kw.out.Push(NewFirstOutputMessage(latency), NewStdioOffsetMessage[K](b, offset))
+    } else {
+        kw.out.Push(NewStdioOffsetMessage[K](b, offset))
+    }
+    if kw.scan != nil {
+        kw.scan(b)
+    }
     return len(b), nil
 }
 
 func (cmd *Cmd) pipeInput(stdin <-chan Input, in io.WriteCloser) {
     defer in.Close()
-    defer cmd.cancel()
 
     for cmd.ctx.Err() == nil {
         select {
         case <-cmd.ctx.Done():
             return
         case data, ok := <-stdin:
-            if ok {
+            if ok && data == EOFInput {
+                cmd.inputWritten(0)
+                err := in.Close()
+                cmd.out.Push(NewStdinClosedMessage())
+                if err != nil {
+                    slog.Error("close stdin", "error", err)
+                }
+                return
+            } else if secret, isSecret := data.(secretInput); ok && isSecret {
+                // The secret is not mirrored anywhere, only its size is counted.
+                n, err := in.Write(secret.data)
+                cmd.meter.stdin.Add(int64(n))
+                cmd.inputWritten(len(secret.data))
+                clear(secret.data)
+                if err != nil {
+                    return
+                }
+            } else if ok {
                 b := data.Input()
                 n, err := in.Write(b)
-                cmd.out.Push(NewStdioMessage[StdinMessage](b[:n]))
+                offset := cmd.meter.stdin.Add(int64(n)) - int64(n)
+                cmd.inputWritten(len(b))
+                if cmd.tee != nil {
+                    _, _ = cmd.tee.Write(b[:n])
+                }
+                cmd.out.Push(NewStdioOffsetMessage[StdinMessage](b[:n], offset))
                 if err != nil {
                     return
-                } else if n <= len(b) {
+                } else if n < len(b) {
                     slog.Error("incomplete write of stdin")
                 }
             } else {
@@ -0,0 +1,59 @@
+package subflow
+
+import (
+    "context"
+    "os"
+    "os/exec"
+    "runtime"
+    "slices"
+    "testing"
+    "time"
+)
+
+func TestWrapKeepsExtraFiles(t *testing.T) {
+    if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+        t.Skip("extra files need a unix shell")
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    preset, presetW, err := os.Pipe()
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer preset.Close()
+    defer presetW.Close()
+    extra, extraW, err := os.Pipe()
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer extra.Close()
+    defer extraW.Close()
+    presetW.WriteString("a\n")
+    extraW.WriteString("b\n")
+
+    c := exec.Command("sh", "-c", `read a <&3; read b <&4; echo "$a$b"`)
+    c.ExtraFiles = []*os.File{preset}
+    cmd, err := Wrap(ctx, c, WithExtraFiles(extra))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer cmd.Close()
+    msgs := cmd.Listen(ctx)
+    cmd.Start()
+
+    var stdout []byte
+    for msg := range msgs {
+        switch msg := msg.(type) {
+        case StartMessage:
+            if !slices.Equal(msg.ExtraFiles, []int{4}) {
+                t.Errorf("start message reports extra files %v, want [4]", msg.ExtraFiles)
+            }
+        case StdoutMessage:
+            stdout = append(stdout, msg.Data...)
+        }
+    }
+    if got := string(stdout); got != "ab\n" {
+        t.Errorf("stdout = %q, want %q", got, "ab\n")
+    }
+}
@@ -0,0 +1,73 @@
+package subflow
+
+import (
+    "bytes"
+    "regexp"
+)
+
+// maxFatalLine is the longest line matched against fatal patterns, longer lines are matched in parts.
+const maxFatalLine = 64 * 1024
+
+// FatalPattern classifies output lines which indicate that a process is about to die or hang, e.g. "panic:" or "OutOfMemoryError".
+type FatalPattern struct {
+    // Class names what the pattern indicates in the FatalIndicationMessage.
+    Class   string
+    Pattern *regexp.Regexp
+    // Terminate kills the process right away once a line matches, its exit reason is ExitFatal by ActorSubflow.
+    // Restarting it is left to the caller, e.g. on the exit message.
+    Terminate bool
+}
+
+// scanner returns a function scanning the output stream for fatal patterns and prompts, or nil if there are none.
+func (cmd *Cmd) scanner(stream string) func([]byte) {
+    fatal, prompt := cmd.fatalScanner(stream), cmd.promptScanner()
+    switch {
+    case fatal == nil:
+        return prompt
+    case prompt == nil:
+        return fatal
+    }
+    return func(b []byte) {
+        fatal(b)
+        prompt(b)
+    }
+}
+
+// fatalScanner returns a function matching the lines of the output stream against the patterns of WithFatalPatterns,
+// or nil if there are none. The function must not be called concurrently.
+func (cmd *Cmd) fatalScanner(stream string) func([]byte) {
+    patterns := cmd.opts.fatalPatterns
+    if len(patterns) == 0 {
+        return nil
+    }
+    var partial []byte
+    return func(b []byte) {
+        partial = append(partial, b...)
+        for {
+            line, rest, ok := bytes.Cut(partial, []byte("\n"))
+            if !ok && len(partial) < maxFatalLine {
+                break
+            } else if !ok {
+                line, rest = partial, nil
+            }
+            cmd.matchFatal(stream, line)
+            partial = rest
+        }
+        // Do not keep the consumed lines alive.
+        partial = append([]byte(nil), partial...)
+    }
+}
+
+// matchFatal emits a FatalIndicationMessage for the first pattern matching line, and kills the process if it terminates.
+func (cmd *Cmd) matchFatal(stream string, line []byte) {
+    for _, p := range cmd.opts.fatalPatterns {
+        if !p.Pattern.Match(line) {
+            continue
+        }
+        cmd.out.Push(NewFatalIndicationMessage(p.Class, stream, line, p.Terminate))
+        if p.Terminate && cmd.setCause(ExitFatal, ActorSubflow) {
+            cmd.cancel()
+        }
+        return
+    }
+}
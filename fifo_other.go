@@ -0,0 +1,13 @@
+//go:build !unix
+
+package subflow
+
+import "os/exec"
+
+// namedPipes connects the stdio of c to the named pipes of the options.
+func (o *options) namedPipes(*exec.Cmd, *resources) error {
+    if o.stdinPipe != "" || o.stdoutPipe != "" {
+        return unsupported("named pipes")
+    }
+    return nil
+}
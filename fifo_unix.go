@@ -0,0 +1,81 @@
+//go:build unix
+
+package subflow
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "io/fs"
+    "os"
+    "os/exec"
+    "syscall"
+    "time"
+)
+
+// namedPipeDrain is how long output is still read from a named pipe after the child exits,
+// other processes may keep the pipe open so it can not be read until closed.
+const namedPipeDrain = 100 * time.Millisecond
+
+// namedPipes connects the stdio of c to the named pipes of the options.
+func (o *options) namedPipes(c *exec.Cmd, res *resources) error {
+    if o.stdinPipe != "" {
+        r, w, err := openNamedPipe(o.stdinPipe)
+        if err != nil {
+            return err
+        }
+        // The write end becomes the stdin of the command, which may already have closed it.
+        res.cleanup = append(res.cleanup, r.Close, func() error { return closeStdin(w) })
+        // The child reads from this end and expects it to block.
+        if err := syscall.SetNonblock(int(r.Fd()), false); err != nil {
+            return fmt.Errorf("named pipe %s: %w", o.stdinPipe, err)
+        }
+        c.Stdin, res.stdin = r, w
+    }
+
+    if o.stdoutPipe != "" {
+        r, w, err := openNamedPipe(o.stdoutPipe)
+        if err != nil {
+            return err
+        }
+        res.cleanup = append(res.cleanup, r.Close, w.Close)
+
+        out := c.Stdout
+        c.Stdout = w
+        done := make(chan error, 1)
+        go func() {
+            _, err := io.Copy(out, r)
+            done <- err
+        }()
+        res.drain = append(res.drain, func() error {
+            _ = r.SetReadDeadline(time.Now().Add(namedPipeDrain))
+            if err := <-done; err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+                return fmt.Errorf("read %s: %w", o.stdoutPipe, err)
+            }
+            return nil
+        })
+    }
+    return nil
+}
+
+// openNamedPipe opens both ends of the named pipe at path, creating it if it does not exist.
+func openNamedPipe(path string) (r, w *os.File, _ error) {
+    if err := syscall.Mkfifo(path, 0o600); err != nil && !errors.Is(err, fs.ErrExist) {
+        return nil, nil, fmt.Errorf("create named pipe: %w", err)
+    } else if fi, err := os.Stat(path); err != nil {
+        return nil, nil, err
+    } else if fi.Mode().Type() != fs.ModeNamedPipe {
+        return nil, nil, fmt.Errorf("%s is not a named pipe", path)
+    }
+
+    // Opening the read end without blocking lets the write end be opened right after it.
+    r, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+    if err != nil {
+        return nil, nil, err
+    }
+    w, err = os.OpenFile(path, os.O_WRONLY, 0)
+    if err != nil {
+        return nil, nil, errors.Join(err, r.Close())
+    }
+    return r, w, nil
+}
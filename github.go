@@ -0,0 +1,113 @@
+package subflow
+
+import (
+    "fmt"
+    "io"
+    "os"
+    "regexp"
+    "strings"
+)
+
+var _ Sink = (*GitHubActions)(nil)
+
+// GitHubActions is a Sink writing the output of a command to w, folded into a log group when running inside GitHub Actions.
+// Stderr lines matching one of the error patterns and a non-zero exit code are reported as error annotations after the group.
+// Workflow commands are disabled while the output is written, so the command can not issue its own.
+// Outside of GitHub Actions only the output is written.
+type GitHubActions struct {
+    w       io.Writer
+    title   string
+    errors  []*regexp.Regexp
+    enabled bool
+    token   string
+
+    lines       lineDecoder
+    annotations []string
+    err         error
+}
+
+// NewGitHubActions returns a GitHubActions sink writing to w, with the log group named title.
+func NewGitHubActions(w io.Writer, title string, errors ...*regexp.Regexp) *GitHubActions {
+    return &GitHubActions{
+        w:       w,
+        title:   title,
+        errors:  errors,
+        enabled: InGitHubActions(),
+    }
+}
+
+// InGitHubActions reports if the current process runs inside GitHub Actions.
+func InGitHubActions() bool {
+    return os.Getenv("GITHUB_ACTIONS") == "true"
+}
+
+func (gh *GitHubActions) Write(msg Message) error {
+    gh.lines.decode(msg, gh.line)
+    return gh.err
+}
+
+// Close writes the incomplete lines left, it does not close the underlying writer.
+func (gh *GitHubActions) Close() error {
+    gh.lines.flush(gh.line)
+    return gh.err
+}
+
+func (gh *GitHubActions) line(msg Message) bool {
+    switch msg := msg.(type) {
+    case StartMessage:
+        if gh.enabled {
+            gh.token = newStopToken()
+            gh.printf("::group::%s\n::stop-commands::%s\n", escapeWorkflowData(gh.title), gh.token)
+        }
+    case StdoutMessage:
+        gh.output(msg.Data)
+    case StderrMessage:
+        gh.output(msg.Data)
+        for _, re := range gh.errors {
+            if re.Match(msg.Data) {
+                gh.annotations = append(gh.annotations, strings.TrimRight(string(msg.Data), "\r\n"))
+                break
+            }
+        }
+    case ExitMessage:
+        if msg.Code != 0 {
+            gh.annotations = append(gh.annotations, fmt.Sprintf("exit code %d (%s)", msg.Code, msg.Reason))
+        }
+        if gh.enabled {
+            gh.printf("::%s::\n::endgroup::\n", gh.token)
+            for _, a := range gh.annotations {
+                gh.printf("::error title=%s::%s\n", escapeWorkflowProperty(gh.title), escapeWorkflowData(a))
+            }
+        }
+    }
+    return gh.err == nil
+}
+
+// output writes a line of output, completing an incomplete last line.
+func (gh *GitHubActions) output(line []byte) {
+    if gh.err == nil {
+        _, gh.err = gh.w.Write(line)
+    }
+    if len(line) > 0 && line[len(line)-1] != '\n' {
+        gh.printf("\n")
+    }
+}
+
+func (gh *GitHubActions) printf(format string, a ...any) {
+    if gh.err == nil {
+        _, gh.err = fmt.Fprintf(gh.w, format, a...)
+    }
+}
+
+// newStopToken returns a random token to resume workflow commands with.
+func newStopToken() string {
+    return randomHex(16)
+}
+
+var (
+    workflowData     = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+    workflowProperty = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C")
+)
+
+func escapeWorkflowData(s string) string     { return workflowData.Replace(s) }
+func escapeWorkflowProperty(s string) string { return workflowProperty.Replace(s) }
@@ -0,0 +1,100 @@
+package subflow
+
+import (
+    "encoding/xml"
+    "fmt"
+    "io"
+    "time"
+)
+
+// JUnitCase is a run of a command reported as a testcase by WriteJUnit.
+type JUnitCase struct {
+    Name     string
+    Messages []Message
+}
+
+// WriteJUnit writes the runs in cases as a JUnit XML testsuite named suite to w, so CI systems can display them.
+// Each run is a testcase with its duration, a run which did not exit with code 0 fails with its exit code and stderr.
+func WriteJUnit(w io.Writer, suite string, cases ...JUnitCase) error {
+    s := junitSuite{Name: suite, Tests: len(cases)}
+    var total time.Duration
+    for _, c := range cases {
+        tc := newJUnitCase(c)
+        if tc.Failure != nil {
+            s.Failures++
+        }
+        total += tc.duration
+        s.Cases = append(s.Cases, tc)
+    }
+    s.Time = junitSeconds(total)
+
+    if _, err := io.WriteString(w, xml.Header); err != nil {
+        return err
+    }
+    enc := xml.NewEncoder(w)
+    enc.Indent("", "  ")
+    if err := enc.Encode(s); err != nil {
+        return err
+    }
+    _, err := io.WriteString(w, "\n")
+    return err
+}
+
+type junitSuite struct {
+    XMLName  xml.Name    `xml:"testsuite"`
+    Name     string      `xml:"name,attr"`
+    Tests    int         `xml:"tests,attr"`
+    Failures int         `xml:"failures,attr"`
+    Time     string      `xml:"time,attr"`
+    Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+    Name     string        `xml:"name,attr"`
+    Time     string        `xml:"time,attr"`
+    Failure  *junitFailure `xml:"failure"`
+    duration time.Duration
+}
+
+type junitFailure struct {
+    Message string `xml:"message,attr"`
+    Stderr  string `xml:",chardata"`
+}
+
+func newJUnitCase(c JUnitCase) junitCase {
+    var start, end time.Time
+    var exit *ExitMessage
+    var stderr []byte
+    for _, msg := range c.Messages {
+        if t := messageTime(msg); start.IsZero() {
+            start, end = t, t
+        } else {
+            end = t
+        }
+        switch msg := msg.(type) {
+        case StderrMessage:
+            stderr = append(stderr, msg.Data...)
+        case ExitMessage:
+            exit = &msg
+        }
+    }
+
+    tc := junitCase{
+        Name:     c.Name,
+        Time:     junitSeconds(end.Sub(start)),
+        duration: end.Sub(start),
+    }
+    if exit == nil {
+        tc.Failure = &junitFailure{Message: "did not exit", Stderr: string(stderr)}
+    } else if exit.Code != 0 {
+        tc.Failure = &junitFailure{
+            Message: fmt.Sprintf("exit code %d (%s)", exit.Code, exit.Reason),
+            Stderr:  string(stderr),
+        }
+    }
+    return tc
+}
+
+func junitSeconds(d time.Duration) string {
+    return fmt.Sprintf("%.3f", d.Seconds())
+}
@@ -0,0 +1,136 @@
+package subflow
+
+import (
+    "context"
+    "errors"
+    "io/fs"
+    "os/exec"
+    "slices"
+    "sync/atomic"
+    "time"
+)
+
+// defaultKeepaliveStable is how long a run must last for the attempts of a Keepalive to start over, see Keepalive.ResetAfter.
+const defaultKeepaliveStable = time.Minute
+
+// Keepalive keeps a command running, starting it again with a delay from its Backoff every time it exits, until it is closed.
+// The messages of every run are forwarded to its listeners, each restart is preceded by a RestartMessage.
+// A command which can not be started because it is missing or not permitted to run is not restarted,
+// an ErrorMessage is emitted and the Keepalive stops with the start error.
+type Keepalive struct {
+    command Command
+    backoff Backoff
+    opts    []Option
+    stable  time.Duration
+
+    out     outStream
+    ctx     context.Context
+    cancel  context.CancelFunc
+    current atomic.Pointer[Cmd]
+    started atomic.Bool
+    done    chan struct{}
+    err     error
+}
+
+// NewKeepalive creates a Keepalive for the command described by cmd, every run is created like New(ctx, cmd, opts...).
+// A nil backoff restarts the command right away.
+func NewKeepalive(ctx context.Context, cmd Command, backoff Backoff, opts ...Option) *Keepalive {
+    if backoff == nil {
+        backoff = ConstantBackoff(0)
+    }
+    ctx, cancel := context.WithCancel(ctx)
+    return &Keepalive{
+        command: cmd,
+        backoff: backoff,
+        opts:    opts,
+        stable:  defaultKeepaliveStable,
+        ctx:     ctx,
+        cancel:  cancel,
+        done:    make(chan struct{}),
+    }
+}
+
+// ResetAfter sets how long a run must last for the following restart to count as the first attempt again, one minute by default.
+// It must be called before Start.
+func (k *Keepalive) ResetAfter(d time.Duration) {
+    k.stable = d
+}
+
+// Start starts the first run exactly once.
+func (k *Keepalive) Start() {
+    if k.started.CompareAndSwap(false, true) {
+        go k.run()
+    }
+}
+
+// Listen returns a channel receiving the messages of every run after the call, like Cmd.Listen.
+// The channel is closed once the Keepalive is done.
+func (k *Keepalive) Listen(ctx context.Context, opts ...ListenOption) <-chan Message {
+    return k.out.view(ctx, opts)
+}
+
+// Cmd returns the current run, or nil before the first one was created.
+func (k *Keepalive) Cmd() *Cmd {
+    return k.current.Load()
+}
+
+// Done returns a channel that closes when the Keepalive stopped restarting its command and the last run has exited.
+func (k *Keepalive) Done() <-chan struct{} {
+    return k.done
+}
+
+// Close stops the current run and every restart, returning the error which stopped the Keepalive on its own, if any.
+func (k *Keepalive) Close() error {
+    k.cancel()
+    if k.started.CompareAndSwap(false, true) {
+        close(k.done)
+        k.out.Close()
+    }
+    <-k.done
+    return k.err
+}
+
+func (k *Keepalive) run() {
+    defer close(k.done)
+    defer k.out.Close()
+    for attempt := 0; ; attempt++ {
+        if attempt > 0 {
+            delay := k.backoff.Delay(attempt)
+            k.out.Push(NewRestartMessage(attempt, delay))
+            select {
+            case <-k.ctx.Done():
+                return
+            case <-time.After(delay):
+            }
+        }
+
+        cmd, err := New(k.ctx, k.command, append(slices.Clip(k.opts), withAttempt(attempt+1))...)
+        if err != nil {
+            // The command can not be created, which does not change by retrying.
+            k.err = err
+            return
+        }
+        k.current.Store(cmd)
+        // The output of a run is closed after its exit message.
+        msgs := cmd.Listen(context.Background())
+        started := time.Now()
+        err = cmd.StartErr()
+        for msg := range msgs {
+            k.out.Push(msg)
+        }
+        if k.ctx.Err() != nil {
+            return
+        } else if permanentStartErr(err) {
+            k.err = err
+            k.out.Push(NewErrorMessage("keepalive", err))
+            return
+        } else if time.Since(started) >= k.stable {
+            attempt = 0
+        }
+    }
+}
+
+// permanentStartErr reports if err keeps a command from starting no matter how often it is retried.
+func permanentStartErr(err error) bool {
+    return errors.Is(err, exec.ErrNotFound) || errors.Is(err, exec.ErrDot) || errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission)
+}
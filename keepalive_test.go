@@ -0,0 +1,31 @@
+package subflow
+
+import (
+    "context"
+    "runtime"
+    "testing"
+    "time"
+)
+
+func TestKeepaliveNilBackoff(t *testing.T) {
+    if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+        t.Skip("needs true")
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+    k := NewKeepalive(ctx, NewCommand("true"), nil)
+    defer k.Close()
+    restarts := k.Listen(ctx, WithKinds(RestartMessage{}))
+    k.Start()
+
+    for want := 1; want <= 2; want++ {
+        msg, ok := <-restarts
+        if !ok {
+            t.Fatalf("keepalive stopped before restart %d: %v", want, k.Close())
+        }
+        restart := msg.(RestartMessage)
+        if restart.Attempt != want || restart.Delay != 0 {
+            t.Errorf("restart = attempt %d after %s, want attempt %d right away", restart.Attempt, restart.Delay, want)
+        }
+    }
+}
@@ -0,0 +1,409 @@
+package subflow
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "fmt"
+    "github.com/bobcatalyst/flow"
+    "reflect"
+    "slices"
+    "sync"
+    "time"
+)
+
+// ListenOption configures the view of a single listener returned by Cmd.Listen, without affecting other listeners.
+type ListenOption func(*listenOptions)
+
+type listenOptions struct {
+    decode Decode
+    split  bufio.SplitFunc
+    kinds  []reflect.Type
+    replay int
+}
+
+func newListenOptions(opts []ListenOption) *listenOptions {
+    lo := new(listenOptions)
+    for _, opt := range opts {
+        opt(lo)
+    }
+    return lo
+}
+
+// Decode is how a listener receives the stdio of a command.
+type Decode int
+
+const (
+    // DecodeChunks emits stdio messages as they were read or written, which is the default.
+    DecodeChunks Decode = iota
+    // DecodeLines emits a stdio message for every line including its newline.
+    // An incomplete last line of a stream is emitted when the command exits.
+    DecodeLines
+)
+
+// WithDecode sets how the listener receives the stdio of the command.
+func WithDecode(decode Decode) ListenOption {
+    return func(lo *listenOptions) { lo.decode = decode }
+}
+
+// WithFramer emits a stdio message for every frame split from the stdio of the command by split, like a bufio.Scanner,
+// e.g. bufio.ScanLines for lines without their newline. It takes precedence over WithDecode.
+// Every listener frames the output on its own, so e.g. one listener can archive raw chunks while another decodes NDJSON lines.
+// An incomplete last frame is split at EOF when the command exits, data which split fails on is dropped.
+func WithFramer(split bufio.SplitFunc) ListenOption {
+    return func(lo *listenOptions) { lo.split = split }
+}
+
+// WithKinds only emits the messages with the same type as one of kinds, e.g.
+//
+//	cmd.Listen(ctx, WithKinds(StdoutMessage{}, ExitMessage{}))
+func WithKinds(kinds ...Message) ListenOption {
+    return func(lo *listenOptions) {
+        for _, k := range kinds {
+            lo.kinds = append(lo.kinds, reflect.TypeOf(k))
+        }
+    }
+}
+
+// WithReplay emits up to the last n messages kept by WithHistory before any new message, a negative n replays all of them.
+func WithReplay(n int) ListenOption {
+    return func(lo *listenOptions) { lo.replay = n }
+}
+
+// raw reports if the listener receives the messages unchanged.
+func (lo *listenOptions) raw() bool {
+    return lo.decode == DecodeChunks && lo.split == nil && len(lo.kinds) == 0 && lo.replay == 0
+}
+
+// keep reports if msg passes the kind filter.
+func (lo *listenOptions) keep(msg Message) bool {
+    return len(lo.kinds) == 0 || slices.Contains(lo.kinds, reflect.TypeOf(msg))
+}
+
+// forward sends the history followed by the messages from in to out, transformed for the listener.
+func (lo *listenOptions) forward(ctx context.Context, history []Message, in <-chan Message, out chan<- Message) {
+    defer close(out)
+    var lines lineDecoder
+    frames := frameDecoder{split: lo.split}
+    send := func(msg Message) bool {
+        if !lo.keep(msg) {
+            return true
+        }
+        select {
+        case <-ctx.Done():
+            return false
+        case out <- msg:
+            return true
+        }
+    }
+    emit := func(msg Message) bool {
+        if lo.split != nil {
+            return frames.decode(msg, send)
+        } else if lo.decode == DecodeLines {
+            return lines.decode(msg, send)
+        }
+        return send(msg)
+    }
+
+    for _, msg := range history {
+        if !emit(msg) {
+            return
+        }
+    }
+    for msg := range in {
+        if !emit(msg) {
+            return
+        }
+    }
+}
+
+// pending is the incomplete data of a stream, starting at offset.
+type pending struct {
+    data   []byte
+    offset int64
+}
+
+// take returns data appended to the pending data, and the offset of the result.
+func (p *pending) take(data []byte, offset int64) ([]byte, int64) {
+    if len(p.data) > 0 {
+        offset = p.offset
+    }
+    data = append(p.data, data...)
+    p.data = nil
+    return data, offset
+}
+
+// keep stores a copy of data starting at offset as pending.
+func (p *pending) keep(data []byte, offset int64) {
+    p.data, p.offset = slices.Clone(data), offset
+}
+
+// lineDecoder splits stdio messages into lines, keeping incomplete lines until they are completed or the command exits.
+type lineDecoder struct {
+    stdin, stdout, stderr pending
+}
+
+// decode passes the lines of msg, or msg itself if it is not stdio, to send.
+func (ld *lineDecoder) decode(msg Message, send func(Message) bool) bool {
+    switch msg := msg.(type) {
+    case StdinMessage:
+        return splitLines(&ld.stdin, msg, send)
+    case StdoutMessage:
+        return splitLines(&ld.stdout, msg, send)
+    case StderrMessage:
+        return splitLines(&ld.stderr, msg, send)
+    case ExitMessage:
+        return ld.flush(send) && send(msg)
+    }
+    return send(msg)
+}
+
+// flush passes the incomplete lines to send.
+func (ld *lineDecoder) flush(send func(Message) bool) bool {
+    return flushLine(&ld.stdin, NewStdioOffsetMessage[StdinMessage, []byte], send) &&
+        flushLine(&ld.stdout, NewStdioOffsetMessage[StdoutMessage, []byte], send) &&
+        flushLine(&ld.stderr, NewStdioOffsetMessage[StderrMessage, []byte], send)
+}
+
+func splitLines[K fmt.Stringer](buf *pending, msg stdioMessage[K], send func(Message) bool) bool {
+    data, offset := buf.take(msg.Data, msg.Offset)
+    for {
+        i := bytes.IndexByte(data, '\n')
+        if i < 0 {
+            break
+        }
+        line := msg
+        line.Data = slices.Clone(data[:i+1])
+        line.Offset = offset
+        if !send(line) {
+            return false
+        }
+        data = data[i+1:]
+        offset += int64(i + 1)
+    }
+    buf.keep(data, offset)
+    return true
+}
+
+func flushLine(buf *pending, newMessage func([]byte, int64) Message, send func(Message) bool) bool {
+    if len(buf.data) == 0 {
+        return true
+    }
+    data, offset := buf.take(nil, 0)
+    return send(newMessage(data, offset))
+}
+
+// frameDecoder splits stdio messages into frames, keeping incomplete frames until they are completed or the command exits.
+type frameDecoder struct {
+    split                 bufio.SplitFunc
+    stdin, stdout, stderr pending
+}
+
+// decode passes the frames of msg, or msg itself if it is not stdio, to send.
+func (fd *frameDecoder) decode(msg Message, send func(Message) bool) bool {
+    switch msg := msg.(type) {
+    case StdinMessage:
+        return fd.frames(&fd.stdin, msg.Data, msg.Offset, false, withData(msg), send)
+    case StdoutMessage:
+        return fd.frames(&fd.stdout, msg.Data, msg.Offset, false, withData(msg), send)
+    case StderrMessage:
+        return fd.frames(&fd.stderr, msg.Data, msg.Offset, false, withData(msg), send)
+    case ExitMessage:
+        return fd.flush(send) && send(msg)
+    }
+    return send(msg)
+}
+
+// flush splits the incomplete frames at EOF and passes them to send.
+func (fd *frameDecoder) flush(send func(Message) bool) bool {
+    return fd.frames(&fd.stdin, nil, 0, true, NewStdioOffsetMessage[StdinMessage, []byte], send) &&
+        fd.frames(&fd.stdout, nil, 0, true, NewStdioOffsetMessage[StdoutMessage, []byte], send) &&
+        fd.frames(&fd.stderr, nil, 0, true, NewStdioOffsetMessage[StderrMessage, []byte], send)
+}
+
+// frames appends data starting at offset to buf and passes every complete frame to send, keeping the rest in buf.
+// The offset of a frame is where the data it was split from starts.
+func (fd *frameDecoder) frames(buf *pending, data []byte, offset int64, atEOF bool, newMessage func([]byte, int64) Message, send func(Message) bool) bool {
+    data, offset = buf.take(data, offset)
+    for len(data) > 0 {
+        advance, token, err := fd.split(data, atEOF)
+        if token != nil && !send(newMessage(slices.Clone(token), offset)) {
+            return false
+        }
+        if err != nil || advance <= 0 || advance > len(data) {
+            if err == nil && advance == 0 && !atEOF {
+                buf.keep(data, offset)
+            }
+            return true
+        }
+        data = data[advance:]
+        offset += int64(advance)
+    }
+    return true
+}
+
+// withData returns a function copying msg with other data and offset.
+func withData[K fmt.Stringer](msg stdioMessage[K]) func([]byte, int64) Message {
+    return func(b []byte, offset int64) Message {
+        msg.Data, msg.Offset = b, offset
+        return msg
+    }
+}
+
+// outStream is the output stream of a command, it keeps the last limit messages for replay.
+// Contiguous stdio messages of the same kind are merged in the history up to compact bytes.
+type outStream struct {
+    flow.Stream[Message]
+    lock    sync.Mutex
+    limit   int
+    compact int
+    history []Message
+    closed  bool
+    // seq counts the messages pushed, seqs holds the number of the last message in every history entry
+    // and base the number of the last message dropped from the history.
+    seq  int64
+    seqs []int64
+    base int64
+}
+
+func (s *outStream) Push(msg ...Message) {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    s.record(msg)
+    s.Stream.Push(msg...)
+}
+
+func (s *outStream) Close(msg ...Message) {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    s.record(msg)
+    s.closed = true
+    s.Stream.Close(msg...)
+}
+
+func (s *outStream) record(msg []Message) {
+    if s.closed {
+        return
+    }
+    seq := s.seq
+    s.seq += int64(len(msg))
+    if s.limit <= 0 {
+        s.base = s.seq
+        return
+    }
+    for _, msg := range msg {
+        seq++
+        if n := len(s.history); n > 0 && s.compact > 0 {
+            if merged, ok := compactStdio(s.history[n-1], msg, s.compact); ok {
+                s.history[n-1] = merged
+                s.seqs[n-1] = seq
+                continue
+            }
+        }
+        s.history = append(s.history, msg)
+        s.seqs = append(s.seqs, seq)
+    }
+    if over := len(s.history) - s.limit; over > 0 {
+        s.base = s.seqs[over-1]
+        s.history = slices.Delete(s.history, 0, over)
+        s.seqs = slices.Delete(s.seqs, 0, over)
+    }
+}
+
+// compactStdio merges msg into last if both are stdio messages of the same kind and the merged data fits in size bytes.
+func compactStdio(last, msg Message, size int) (Message, bool) {
+    switch last := last.(type) {
+    case StdinMessage:
+        return mergeStdio(last, msg, size)
+    case StdoutMessage:
+        return mergeStdio(last, msg, size)
+    case StderrMessage:
+        return mergeStdio(last, msg, size)
+    }
+    return nil, false
+}
+
+func mergeStdio[K fmt.Stringer](last stdioMessage[K], msg Message, size int) (Message, bool) {
+    next, ok := msg.(stdioMessage[K])
+    if !ok || len(last.Data)+len(next.Data) > size {
+        return nil, false
+    }
+    if last.Until == nil {
+        // The data is shared with the listeners until it was merged once,
+        // later merges only append past the data replayed so far.
+        last.Data = append(make(Data, 0, len(last.Data)+len(next.Data)), last.Data...)
+    }
+    last.Data = append(last.Data, next.Data...)
+    last.Until = &next.Time
+    return last, true
+}
+
+// listen returns the last n messages of the history and a listener for every message after them.
+func (s *outStream) listen(ctx context.Context, n int) (history []Message, c <-chan Message) {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    if n < 0 || n > len(s.history) {
+        n = len(s.history)
+    }
+    return slices.Clone(s.history[len(s.history)-n:]), s.Stream.Listen(ctx)
+}
+
+// resume returns the entries of the history with messages after the message numbered after, with the number of their last message,
+// and a listener for every message after them numbered from next. A negative after starts after the last message.
+// It fails if the messages after after are no longer kept.
+func (s *outStream) resume(ctx context.Context, after int64) (history []Message, seqs []int64, next int64, c <-chan Message, _ error) {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    if after < 0 {
+        after = s.seq
+    } else if after < s.base {
+        return nil, nil, 0, nil, ErrResumeExpired
+    } else if after > s.seq {
+        return nil, nil, 0, nil, fmt.Errorf("resume after message %d of %d", after, s.seq)
+    }
+    i, _ := slices.BinarySearch(s.seqs, after+1)
+    return slices.Clone(s.history[i:]), slices.Clone(s.seqs[i:]), s.seq, s.Stream.Listen(ctx), nil
+}
+
+// view returns a listener transformed by opts.
+func (s *outStream) view(ctx context.Context, opts []ListenOption) <-chan Message {
+    lo := newListenOptions(opts)
+    history, c := s.listen(ctx, lo.replay)
+    if lo.raw() {
+        return c
+    }
+    out := make(chan Message)
+    go lo.forward(ctx, history, c, out)
+    return out
+}
+
+// between returns the messages of the history which were emitted between from and to, inclusive.
+// A merged stdio message is returned if any of the messages merged into it was emitted in that time.
+func (s *outStream) between(from, to time.Time) (msgs []Message) {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    for _, msg := range s.history {
+        if start, end := messageSpan(msg); !end.Before(from) && !start.After(to) {
+            msgs = append(msgs, msg)
+        }
+    }
+    return msgs
+}
+
+// messageSpan returns the time of msg, and of the last message merged into it if it is a merged stdio message.
+func messageSpan(msg Message) (start, end time.Time) {
+    var until *time.Time
+    switch msg := msg.(type) {
+    case StdinMessage:
+        until = msg.Until
+    case StdoutMessage:
+        until = msg.Until
+    case StderrMessage:
+        until = msg.Until
+    }
+    start = messageTime(msg)
+    if until != nil {
+        return start, *until
+    }
+    return start, start
+}
@@ -3,6 +3,7 @@ package subflow
 import (
     "encoding/json"
     "fmt"
+    "os"
     "reflect"
     "slices"
     "time"
@@ -31,6 +32,8 @@ func NewBaseMessage[K fmt.Stringer]() BaseMessage[K] {
 
 func (BaseMessage[K]) message() {}
 
+func (bm BaseMessage[K]) messageTime() time.Time { return bm.Time }
+
 // JSONString wraps a type that implements fmt.Stringer for JSON serialization.
 type JSONString[S fmt.Stringer] struct{}
 
@@ -85,29 +88,379 @@ type (
     stdout struct{}
     stdin  struct{}
     text   struct{}
+    frozen struct{}
+    thawed struct{}
+    swap   struct{}
+    signal struct{}
+
+    firstOutput   struct{}
+    limitExceeded struct{}
+    cgroupUsage   struct{}
+    usage         struct{}
+    suspended     struct{}
+    resumed       struct{}
+    inputRejected struct{}
+    timeout       struct{}
+    inputQuota    struct{}
+    meter         struct{}
+    idleTimeout   struct{}
+    fatal         struct{}
+    restart       struct{}
+    failure       struct{}
+    kill          struct{}
+    eof           struct{}
+    stdinClosed   struct{}
+    secret        struct{}
 )
 
 type (
     // StartMessage represents a message indicating the start of a process.
     StartMessage struct {
         BaseMessage[kind[start]]
+        // Pid is the process id of the started process, or 0 if it failed to start.
+        Pid int `json:"pid"`
+        // ExtraFiles are the file descriptors of the files passed with WithExtraFiles.
+        ExtraFiles []int `json:"extraFiles,omitempty"`
+        // RunID is the id of the run set by WithRunEnv.
+        RunID string `json:"runId,omitempty"`
     }
 
-    // ExitMessage represents a message indicating the end of a process, including the exit code.
+    // ExitMessage represents a message indicating the end of a process, including the exit code,
+    // why the process ended and who initiated it.
     ExitMessage struct {
         BaseMessage[kind[exit]]
-        Code int `json:"code"`
+        Code   int        `json:"code"`
+        Reason ExitReason `json:"reason"`
+        Actor  ExitActor  `json:"actor"`
+        Stats  Stats      `json:"stats"`
+    }
+
+    // FirstOutputMessage represents a message indicating the first output of a process, emitted right before it with WithFirstOutputMessage.
+    FirstOutputMessage struct {
+        BaseMessage[kind[firstOutput]]
+        Latency time.Duration `json:"latency"`
     }
 )
 
+// TimeoutMessage represents a message indicating the process ran longer than the deadline of WithDeadline and is killed,
+// emitted right before its exit.
+type TimeoutMessage struct {
+    BaseMessage[kind[timeout]]
+    Deadline time.Duration `json:"deadline"`
+}
+
+func NewTimeoutMessage(deadline time.Duration) Message {
+    return TimeoutMessage{
+        BaseMessage: NewBaseMessage[kind[timeout]](),
+        Deadline:    deadline,
+    }
+}
+
+// IdleTimeoutMessage represents a message indicating the process did not output anything for the timeout of WithIdleTimeout and is killed,
+// emitted right before its exit.
+type IdleTimeoutMessage struct {
+    BaseMessage[kind[idleTimeout]]
+    // Idle is how long the process has been silent.
+    Idle time.Duration `json:"idle"`
+}
+
+func NewIdleTimeoutMessage(idle time.Duration) Message {
+    return IdleTimeoutMessage{
+        BaseMessage: NewBaseMessage[kind[idleTimeout]](),
+        Idle:        idle,
+    }
+}
+
+// StdinClosedMessage represents a message indicating the stdin of the process was closed by EOFInput.
+type StdinClosedMessage struct {
+    BaseMessage[kind[stdinClosed]]
+}
+
+func NewStdinClosedMessage() Message {
+    return StdinClosedMessage{BaseMessage: NewBaseMessage[kind[stdinClosed]]()}
+}
+
+// KillMessage represents a message indicating the process is killed with Cmd.Kill, emitted right before it is killed.
+type KillMessage struct {
+    BaseMessage[kind[kill]]
+}
+
+func NewKillMessage() Message {
+    return KillMessage{BaseMessage: NewBaseMessage[kind[kill]]()}
+}
+
+// ErrorMessage represents a message indicating a failure next to the process which does not stop it,
+// e.g. a Sink or a writer of WithStdinTee which receives no more output, or a prompt of WithPrompts which was not answered.
+type ErrorMessage struct {
+    BaseMessage[kind[failure]]
+    // Source names what failed, e.g. "sink", "stdout" for the Stdout of a wrapped exec.Cmd, "stdout file" or "prompt".
+    Source string `json:"source"`
+    Error  string `json:"error"`
+}
+
+func NewErrorMessage(source string, err error) Message {
+    return ErrorMessage{
+        BaseMessage: NewBaseMessage[kind[failure]](),
+        Source:      source,
+        Error:       err.Error(),
+    }
+}
+
+// RestartMessage represents a message of a Keepalive indicating its command exited and is started again after Delay.
+type RestartMessage struct {
+    BaseMessage[kind[restart]]
+    // Attempt counts the restarts since the last run which lasted long enough, see Keepalive.ResetAfter, starting at 1.
+    Attempt int           `json:"attempt"`
+    Delay   time.Duration `json:"delay"`
+}
+
+func NewRestartMessage(attempt int, delay time.Duration) Message {
+    return RestartMessage{
+        BaseMessage: NewBaseMessage[kind[restart]](),
+        Attempt:     attempt,
+        Delay:       delay,
+    }
+}
+
+// FatalIndicationMessage represents a message indicating the process wrote a line matching a fatal pattern of WithFatalPatterns,
+// emitted right after the output containing the line.
+type FatalIndicationMessage struct {
+    BaseMessage[kind[fatal]]
+    // Class is the class of the matching pattern, e.g. "panic".
+    Class string `json:"class"`
+    // Stream is "stdout" or "stderr".
+    Stream string `json:"stream"`
+    Line   Data   `json:"line"`
+    // Terminate reports if the process is killed because of the line.
+    Terminate bool `json:"terminate"`
+}
+
+func NewFatalIndicationMessage(class, stream string, line []byte, terminate bool) Message {
+    return FatalIndicationMessage{
+        BaseMessage: NewBaseMessage[kind[fatal]](),
+        Class:       class,
+        Stream:      stream,
+        Line:        slices.Clone(line),
+        Terminate:   terminate,
+    }
+}
+
+// ExitReason is why a process ended.
+type ExitReason string
+
+const (
+    ExitNormal       ExitReason = "normal"            // The process exited on its own.
+    ExitSignal       ExitReason = "signal"            // The process was terminated by a signal from Cmd.Signal or outside of subflow.
+    ExitCancelled    ExitReason = "context-cancelled" // The command was closed or its context was cancelled.
+    ExitWatchdog     ExitReason = "watchdog"          // A watchdog stopped the process.
+    ExitDeadline     ExitReason = "deadline"          // A deadline of the command or its context was exceeded.
+    ExitStartFailure ExitReason = "start-failure"     // The process could not be started.
+    ExitLimit        ExitReason = "limit"             // The process exceeded a resource limit of WithRlimit.
+    ExitFatal        ExitReason = "fatal-output"      // The process wrote output matching a fatal pattern of WithFatalPatterns.
+    ExitKilled       ExitReason = "killed"            // The process was killed with Cmd.Kill.
+)
+
+// ExitActor is who initiated the end of a process.
+type ExitActor string
+
+const (
+    ActorProcess ExitActor = "process" // The process itself, or something outside of subflow.
+    ActorCaller  ExitActor = "caller"  // The caller through a method of Cmd such as Close.
+    ActorContext ExitActor = "context" // The context the command was created with.
+    ActorSubflow ExitActor = "subflow" // Subflow itself, e.g. a watchdog or a failed start.
+)
+
 func NewStartMessage() Message {
-    return StartMessage{BaseMessage: NewBaseMessage[kind[start]]()}
+    return NewStartPidMessage(0)
+}
+
+func NewStartPidMessage(pid int) Message {
+    return NewStartFilesMessage(pid, nil)
+}
+
+func NewStartFilesMessage(pid int, extraFiles []int) Message {
+    return StartMessage{
+        BaseMessage: NewBaseMessage[kind[start]](),
+        Pid:         pid,
+        ExtraFiles:  extraFiles,
+    }
 }
 
+// NewExitMessage creates an ExitMessage for a process which exited on its own.
 func NewExitMessage(code int) Message {
+    return NewExitReasonMessage(code, ExitNormal, ActorProcess)
+}
+
+func NewExitReasonMessage(code int, reason ExitReason, actor ExitActor) Message {
     return ExitMessage{
         BaseMessage: NewBaseMessage[kind[exit]](),
         Code:        code,
+        Reason:      reason,
+        Actor:       actor,
+    }
+}
+
+func NewFirstOutputMessage(latency time.Duration) Message {
+    return FirstOutputMessage{
+        BaseMessage: NewBaseMessage[kind[firstOutput]](),
+        Latency:     latency,
+    }
+}
+
+// LimitExceededMessage represents a message indicating the process was killed by exceeding a resource limit of WithRlimit,
+// emitted right before its exit.
+type LimitExceededMessage struct {
+    BaseMessage[kind[limitExceeded]]
+    // Resource is the exceeded resource, "cpu" or "fsize".
+    Resource string `json:"resource"`
+    Signal   string `json:"signal"`
+}
+
+func NewLimitExceededMessage(resource string, sig os.Signal) Message {
+    return LimitExceededMessage{
+        BaseMessage: NewBaseMessage[kind[limitExceeded]](),
+        Resource:    resource,
+        Signal:      sig.String(),
+    }
+}
+
+// UsageMessage represents a message with the resource usage of the process, emitted periodically with WithUsage.
+type UsageMessage struct {
+    BaseMessage[kind[usage]]
+    // CPU is the CPU used by the process since the previous sample in percent of one CPU, it exceeds 100 for a process using several.
+    CPU float64 `json:"cpu"`
+    // RSS is the resident memory of the process in bytes.
+    RSS int64 `json:"rss"`
+    // FDs is the number of open file descriptors of the process.
+    FDs int `json:"fds"`
+    // ReadBytes and WriteBytes are the bytes the process caused to be read from and written to storage, 0 if they can not be read.
+    ReadBytes  int64 `json:"readBytes"`
+    WriteBytes int64 `json:"writeBytes"`
+}
+
+func NewUsageMessage(cpu float64, rss int64, fds int, readBytes, writeBytes int64) Message {
+    return UsageMessage{
+        BaseMessage: NewBaseMessage[kind[usage]](),
+        CPU:         cpu,
+        RSS:         rss,
+        FDs:         fds,
+        ReadBytes:   readBytes,
+        WriteBytes:  writeBytes,
+    }
+}
+
+// MeterMessage represents a message with the throughput of the stdio of the process in bytes per second
+// since the previous one, emitted periodically with WithMeter.
+type MeterMessage struct {
+    BaseMessage[kind[meter]]
+    Stdin  float64 `json:"stdin"`
+    Stdout float64 `json:"stdout"`
+    Stderr float64 `json:"stderr"`
+}
+
+func NewMeterMessage(stdin, stdout, stderr float64) Message {
+    return MeterMessage{
+        BaseMessage: NewBaseMessage[kind[meter]](),
+        Stdin:       stdin,
+        Stdout:      stdout,
+        Stderr:      stderr,
+    }
+}
+
+// CgroupUsageMessage represents a message with the resource usage of the cgroup of the process, emitted periodically with WithCgroupUsage.
+// Usage of a controller not enabled for the cgroup is 0.
+type CgroupUsageMessage struct {
+    BaseMessage[kind[cgroupUsage]]
+    // Memory is the memory used by the process tree in bytes.
+    Memory int64 `json:"memory"`
+    // CPU is the CPU time used by the process tree since it was started.
+    CPU time.Duration `json:"cpu"`
+    // Pids is the number of processes and threads in the process tree.
+    Pids int `json:"pids"`
+}
+
+func NewCgroupUsageMessage(memory int64, cpu time.Duration, pids int) Message {
+    return CgroupUsageMessage{
+        BaseMessage: NewBaseMessage[kind[cgroupUsage]](),
+        Memory:      memory,
+        CPU:         cpu,
+        Pids:        pids,
+    }
+}
+
+type (
+    // FrozenMessage represents a message indicating the process tree was frozen by Cmd.Freeze.
+    FrozenMessage struct {
+        BaseMessage[kind[frozen]]
+    }
+
+    // ThawedMessage represents a message indicating the process tree was thawed by Cmd.Thaw.
+    ThawedMessage struct {
+        BaseMessage[kind[thawed]]
+    }
+
+    // SuspendedMessage represents a message indicating the process was suspended by Cmd.Suspend.
+    SuspendedMessage struct {
+        BaseMessage[kind[suspended]]
+    }
+
+    // ResumedMessage represents a message indicating the process was resumed by Cmd.Resume.
+    ResumedMessage struct {
+        BaseMessage[kind[resumed]]
+    }
+)
+
+func NewFrozenMessage() Message {
+    return FrozenMessage{BaseMessage: NewBaseMessage[kind[frozen]]()}
+}
+
+func NewThawedMessage() Message {
+    return ThawedMessage{BaseMessage: NewBaseMessage[kind[thawed]]()}
+}
+
+func NewSuspendedMessage() Message {
+    return SuspendedMessage{BaseMessage: NewBaseMessage[kind[suspended]]()}
+}
+
+func NewResumedMessage() Message {
+    return ResumedMessage{BaseMessage: NewBaseMessage[kind[resumed]]()}
+}
+
+// SwapStep is a step of replacing a command with Swap.
+type SwapStep string
+
+const (
+    SwapStarted  SwapStep = "started"  // The new command was started.
+    SwapReady    SwapStep = "ready"    // The new command became ready.
+    SwapStopping SwapStep = "stopping" // The old command is being stopped.
+    SwapDone     SwapStep = "done"     // The old command is closed, reported by it right before and by the new command once it exited.
+    SwapAborted  SwapStep = "aborted"  // The new command did not become ready and was stopped, the old command keeps running.
+)
+
+// SwapMessage represents a step of replacing a command with Swap.
+// It is emitted by both the old and the new command.
+type SwapMessage struct {
+    BaseMessage[kind[swap]]
+    Step SwapStep `json:"step"`
+}
+
+func NewSwapMessage(step SwapStep) Message {
+    return SwapMessage{
+        BaseMessage: NewBaseMessage[kind[swap]](),
+        Step:        step,
+    }
+}
+
+// SignalMessage represents a message indicating a signal was sent to the process with Cmd.Signal.
+type SignalMessage struct {
+    BaseMessage[kind[signal]]
+    Signal string `json:"signal"`
+}
+
+func NewSignalMessage(sig os.Signal) Message {
+    return SignalMessage{
+        BaseMessage: NewBaseMessage[kind[signal]](),
+        Signal:      sig.String(),
     }
 }
 
@@ -116,6 +469,10 @@ type (
         BaseMessage[kind[stdio]]
         Stdio JSONString[K] `json:"stdio"`
         Data  Data          `json:"data"`
+        // Offset is the position of the data in its stream, counting every byte before it.
+        Offset int64 `json:"offset"`
+        // Until is the time of the last message merged into this one by WithCompaction, if any.
+        Until *time.Time `json:"until,omitempty"`
     }
     StdinMessage  = stdioMessage[kind[stdin]]
     StderrMessage = stdioMessage[kind[stderr]]
@@ -150,6 +507,54 @@ func NewStdioMessage[T StdioLike, D DataLike](data D) Message {
     return any(msg).(Message)
 }
 
+// NewStdioOffsetMessage creates a StdioMessage like NewStdioMessage for data starting at offset in its stream.
+func NewStdioOffsetMessage[T StdioLike, D DataLike](data D, offset int64) Message {
+    switch msg := NewStdioMessage[T](data).(type) {
+    case StderrMessage:
+        msg.Offset = offset
+        return msg
+    case StdoutMessage:
+        msg.Offset = offset
+        return msg
+    case StdinMessage:
+        msg.Offset = offset
+        return msg
+    }
+    panic("invalid stdio type")
+}
+
+// InputRejectedMessage represents a message indicating an input was rejected by a filter of WithInputFilter and not written to stdin.
+type InputRejectedMessage struct {
+    BaseMessage[kind[inputRejected]]
+    Data   Data   `json:"data"`
+    Reason string `json:"reason"`
+}
+
+func NewInputRejectedMessage(in Input, reason error) Message {
+    return InputRejectedMessage{
+        BaseMessage: NewBaseMessage[kind[inputRejected]](),
+        Data:        slices.Clone(in.Input()),
+        Reason:      reason.Error(),
+    }
+}
+
+// InputQuotaMessage represents a warning that the inputs pushed to the process exceeded the quota of WithInputQuota,
+// no more inputs are accepted.
+type InputQuotaMessage struct {
+    BaseMessage[kind[inputQuota]]
+    Quota int64 `json:"quota"`
+    // Size is the total size the inputs would have had with the rejected push.
+    Size int64 `json:"size"`
+}
+
+func NewInputQuotaMessage(quota, size int64) Message {
+    return InputQuotaMessage{
+        BaseMessage: NewBaseMessage[kind[inputQuota]](),
+        Quota:       quota,
+        Size:        size,
+    }
+}
+
 // TextInput represents input data as a message.
 type TextInput struct {
     BaseMessage[kind[text]]
@@ -162,6 +567,28 @@ func (ti TextInput) Input() []byte {
 
 func newTextInput[D DataLike](data D) TextInput { return TextInput{Data: []byte(data)} }
 
+// EOFInput closes the stdin of the process once the inputs pushed before it were written, see Cmd.CloseStdin.
+var EOFInput Input = eofInput{}
+
+type eofInput struct {
+    BaseMessage[kind[eof]]
+}
+
+func (eofInput) Input() []byte { return nil }
+
+// NewSecretInput creates an Input for a secret, e.g. a password. It is written to stdin like other inputs,
+// but without a StdinMessage, WithStdinTee or WithInputFilter seeing it, and secret is zeroed once it was written.
+func NewSecretInput(secret []byte) Input {
+    return secretInput{data: secret}
+}
+
+type secretInput struct {
+    BaseMessage[kind[secret]]
+    data []byte
+}
+
+func (si secretInput) Input() []byte { return si.data }
+
 // NewInputln creates a new TextInput with a newline appended.
 func NewInputln[D DataLike](data D) Input {
     return newTextInput(append(slices.Clone([]byte(data)), '\n'))
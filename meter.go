@@ -0,0 +1,28 @@
+package subflow
+
+import (
+    "sync/atomic"
+    "time"
+)
+
+// ioMeter counts the bytes written to stdin and read from stdout and stderr.
+type ioMeter struct {
+    stdin, stdout, stderr atomic.Int64
+}
+
+// reportMeter emits a MeterMessage every interval of WithMeter until stop is called.
+func (cmd *Cmd) reportMeter() (stop func()) {
+    if cmd.opts.meter <= 0 {
+        return func() {}
+    }
+    last := time.Now()
+    var stdin, stdout, stderr int64
+    return cmd.report(cmd.opts.meter, func() Message {
+        now := time.Now()
+        elapsed := now.Sub(last).Seconds()
+        in, out, err := cmd.meter.stdin.Load(), cmd.meter.stdout.Load(), cmd.meter.stderr.Load()
+        msg := NewMeterMessage(float64(in-stdin)/elapsed, float64(out-stdout)/elapsed, float64(err-stderr)/elapsed)
+        last, stdin, stdout, stderr = now, in, out, err
+        return msg
+    })
+}
@@ -0,0 +1,671 @@
+package subflow
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "os/exec"
+    "runtime"
+    "slices"
+    "strconv"
+    "strings"
+    "sync"
+    "syscall"
+    "time"
+)
+
+// Option configures how a command is started by New or Run.
+// The command itself, its arguments, environment and working directory, is described by the Command, not by Options.
+type Option func(*options)
+
+type options struct {
+    umask    *os.FileMode
+    codePage *uint32
+    cmdLine  *string
+
+    seLinuxLabel    string
+    appArmorProfile string
+    ambientCaps     []uintptr
+    boundingCaps    []uintptr
+    restrictCaps    bool
+    noNewPrivs      bool
+    noNetwork       bool
+    privateTmp      bool
+    privateHome     bool
+    readOnlyRoot    bool
+    writable        []string
+    cpuAffinity     []int
+    nice            *int
+    rlimits         []rlimit
+
+    workspace      bool
+    workspaceQuota int64
+    cgroup         string
+    cgroupLimits   *CgroupLimits
+    cgroupUsage    time.Duration
+    usage          time.Duration
+    meter          time.Duration
+
+    stdinTee       []io.Writer
+    history        int
+    inputLimit     int
+    inputByteLimit int64
+    inputFilters   []InputFilter
+    inputQuota     int64
+    deadline       time.Duration
+    idleTimeout    time.Duration
+    fatalPatterns  []FatalPattern
+    prompts        []Prompt
+    sinks          []Sink
+    flushTimeout   time.Duration
+    stdinReaders   []io.Reader
+    bufferedOutput bool
+    compact        int
+    firstOutput    bool
+    processGroup   bool
+    sysProcAttr    *syscall.SysProcAttr
+    credential     *credential
+    extraFiles     []*os.File
+    stdoutFile     *OutputFile
+    stderrFile     *OutputFile
+    requiredPorts  []string
+    deathSignal    os.Signal
+    parentPipe     bool
+    runEnv         bool
+    // attempt counts the runs of a Keepalive, starting at 1.
+    attempt     int
+    sinkRetries int
+    sinkBackoff Backoff
+    // writeError reports the first error of a writer mirroring the stdio, e.g. with an ErrorMessage.
+    writeError func(source string, err error)
+
+    stdinPipe  string
+    stdoutPipe string
+}
+
+func newOptions(opts []Option) *options {
+    o := new(options)
+    for _, opt := range opts {
+        opt(o)
+    }
+    return o
+}
+
+// WithUmask sets the file mode creation mask of the child process,
+// so files it creates have predictable permissions regardless of the parent's umask.
+// It is not supported on Windows.
+func WithUmask(mask os.FileMode) Option {
+    return func(o *options) { o.umask = &mask }
+}
+
+// WithCodePage transcodes the output of the child from the Windows code page cp to UTF-8.
+// A cp of 0 uses the code page of the console, or the OEM code page if there is no console.
+// It only has an effect on Windows.
+func WithCodePage(cp uint32) Option {
+    return func(o *options) { o.codePage = &cp }
+}
+
+// WithCmdLine sets the exact command line passed to the child, bypassing the quoting of arguments.
+// It is meant for programs with non-standard quoting rules such as msiexec or cmd builtins.
+// It is only supported on Windows.
+func WithCmdLine(cmdLine string) Option {
+    return func(o *options) { o.cmdLine = &cmdLine }
+}
+
+// WithSELinuxLabel runs the child in the SELinux security context label.
+// It is only supported on Linux.
+func WithSELinuxLabel(label string) Option {
+    return func(o *options) { o.seLinuxLabel = label }
+}
+
+// WithAppArmorProfile confines the child to the AppArmor profile.
+// It is only supported on Linux.
+func WithAppArmorProfile(profile string) Option {
+    return func(o *options) { o.appArmorProfile = profile }
+}
+
+// WithAmbientCaps raises the capabilities caps in the ambient set of the child,
+// letting an unprivileged child keep them across exec. The caps must be permitted and inheritable in the parent.
+// It is only supported on Linux.
+func WithAmbientCaps(caps ...uintptr) Option {
+    return func(o *options) { o.ambientCaps = append(o.ambientCaps, caps...) }
+}
+
+// WithBoundingCaps restricts the capability bounding set of the child to caps, dropping every other capability.
+// The child and its descendants can never gain a dropped capability, even when executing setuid or file capability binaries.
+// Dropping capabilities requires CAP_SETPCAP. It is only supported on Linux.
+func WithBoundingCaps(caps ...uintptr) Option {
+    return func(o *options) {
+        o.restrictCaps = true
+        o.boundingCaps = append(o.boundingCaps, caps...)
+    }
+}
+
+// WithNoNewPrivs sets the no_new_privs flag on the child,
+// so neither it nor its descendants can gain privileges through setuid or file capability binaries.
+// It is only supported on Linux.
+func WithNoNewPrivs() Option {
+    return func(o *options) { o.noNewPrivs = true }
+}
+
+// WithNoNetwork runs the child in a new network namespace with only the loopback interface,
+// so it can not reach any other host. It requires CAP_SYS_ADMIN and is only supported on Linux.
+func WithNoNetwork() Option {
+    return func(o *options) { o.noNetwork = true }
+}
+
+// WithPrivateTmp gives the child its own empty tmpfs mounted on /tmp, which is destroyed once the child and its descendants exit.
+// It requires CAP_SYS_ADMIN and is only supported on Linux.
+func WithPrivateTmp() Option {
+    return func(o *options) { o.privateTmp = true }
+}
+
+// WithPrivateHome mounts an empty tmpfs over the child's HOME, which is destroyed once the child and its descendants exit.
+// It requires CAP_SYS_ADMIN and is only supported on Linux.
+func WithPrivateHome() Option {
+    return func(o *options) { o.privateHome = true }
+}
+
+// WithReadOnlyRoot makes the whole filesystem read-only for the child, except for the writable paths,
+// a private /tmp and a private home. The kernel filesystems under /proc, /sys and /dev are left as they are.
+// It requires CAP_SYS_ADMIN and is only supported on Linux.
+func WithReadOnlyRoot(writable ...string) Option {
+    return func(o *options) {
+        o.readOnlyRoot = true
+        o.writable = append(o.writable, writable...)
+    }
+}
+
+// WithNice runs the child with the nice value n, from -20 for the highest priority to 19 for the lowest.
+// Lowering the nice value below the current one requires CAP_SYS_NICE or root on Unix.
+// On Unix systems other than Linux the value is set right after the child is started.
+// On Windows n is mapped to the nearest priority class, from high for -15 and below to idle for 15 and above.
+func WithNice(n int) Option {
+    return func(o *options) { o.nice = &n }
+}
+
+// WithCPUAffinity pins the child and its descendants to the CPUs numbered cpus.
+// On Windows the affinity is applied right after the process is created and is limited to the first 64 CPUs, or 32 on 32-bit systems.
+// It is only supported on Linux and Windows.
+func WithCPUAffinity(cpus ...int) Option {
+    return func(o *options) { o.cpuAffinity = append(o.cpuAffinity, cpus...) }
+}
+
+// WithWorkspace runs the child in a new temporary directory, which is also set as its TMPDIR, TMP and TEMP.
+// The directory is removed after the command exits and its exit message has been sent.
+// A quota greater than 0 limits the size of the workspace in bytes by mounting a tmpfs over it,
+// which requires CAP_SYS_ADMIN and is only supported on Linux.
+func WithWorkspace(quota int64) Option {
+    return func(o *options) {
+        o.workspace = true
+        o.workspaceQuota = quota
+    }
+}
+
+// WithExtraFiles passes the open files to the child, e.g. listeners for socket activation.
+// Entry i becomes file descriptor 3+i in the child, as listed in the StartMessage.
+// The files stay owned by the caller, passing files is not supported on Windows.
+func WithExtraFiles(files ...*os.File) Option {
+    return func(o *options) { o.extraFiles = append(o.extraFiles, files...) }
+}
+
+// WithRequiredPorts checks that the TCP addresses, e.g. ":8080" or "127.0.0.1:8080", are free right before the child is started,
+// failing the start with a *PortInUseError instead of letting the child fail to bind them.
+func WithRequiredPorts(addrs ...string) Option {
+    return func(o *options) { o.requiredPorts = append(o.requiredPorts, addrs...) }
+}
+
+// WithParentDeathSignal makes the kernel send sig to the child, e.g. syscall.SIGKILL, once the current process dies,
+// so a crashed supervisor does not leave it running. It is only supported on Linux, see WithParentPipe for other platforms.
+func WithParentDeathSignal(sig os.Signal) Option {
+    return func(o *options) { o.deathSignal = sig }
+}
+
+// WithParentPipe passes the read end of a pipe to the child, its file descriptor is set in the SUBFLOW_PARENT_FD environment variable.
+// Nothing is written to the pipe, it reaches EOF once the current process dies, so a child watching it can exit instead of being left running.
+// Passing files is not supported on Windows.
+func WithParentPipe() Option {
+    return func(o *options) { o.parentPipe = true }
+}
+
+// WithRunEnv sets metadata of the run in the environment of the child, so its own logs can be correlated with the messages:
+// SUBFLOW_RUN_ID is a random id also in the StartMessage and Cmd.RunID, SUBFLOW_ATTEMPT counts the runs of a Keepalive starting at 1,
+// and SUBFLOW_CMD_NAME is the name of the command.
+func WithRunEnv() Option {
+    return func(o *options) { o.runEnv = true }
+}
+
+// withAttempt sets the attempt of WithRunEnv.
+func withAttempt(attempt int) Option {
+    return func(o *options) { o.attempt = attempt }
+}
+
+// WithSysProcAttr starts the child with a copy of attr, for the platform specific attributes not covered by other options.
+// It replaces the attributes set before, e.g. the user of a CommandUser, other options are applied on top of it.
+func WithSysProcAttr(attr *syscall.SysProcAttr) Option {
+    return func(o *options) { o.sysProcAttr = attr }
+}
+
+// WithCredential runs the child as the user uid with the primary group gid and the supplementary groups,
+// which usually requires root. The workspace of WithWorkspace is owned by the user.
+// It is only supported on Unix.
+func WithCredential(uid, gid uint32, groups []uint32) Option {
+    return func(o *options) { o.credential = &credential{uid: uid, gid: gid, groups: groups} }
+}
+
+type credential struct {
+    uid, gid uint32
+    groups   []uint32
+}
+
+// WithProcessGroup starts the child in a new process group, so killing the command also kills the children it started,
+// such as those of shell wrappers or npm. Cmd.Signal signals the whole group too.
+// It is only supported on Unix.
+func WithProcessGroup() Option {
+    return func(o *options) { o.processGroup = true }
+}
+
+// WithCgroup starts the child in a new cgroup created inside the cgroup v2 directory parent,
+// which allows its whole process tree to be frozen with Cmd.Freeze.
+// Processes left in the cgroup after the command exits are killed and the cgroup is removed.
+// It is only supported on Linux.
+func WithCgroup(parent string) Option {
+    return func(o *options) { o.cgroup = parent }
+}
+
+// WithUsage emits a UsageMessage with the resource usage of the process every interval while it runs.
+// Only the process itself is sampled, not its children.
+// It is only supported on Linux.
+func WithUsage(interval time.Duration) Option {
+    return func(o *options) { o.usage = interval }
+}
+
+// WithMeter emits a MeterMessage with the throughput of stdin, stdout and stderr every interval while the process runs.
+func WithMeter(interval time.Duration) Option {
+    return func(o *options) { o.meter = interval }
+}
+
+// CgroupLimits are resource limits of the cgroup of WithCgroup, a zero value leaves its resource unlimited.
+// The controllers of the limits must be enabled in the cgroup.subtree_control of the parent cgroup.
+type CgroupLimits struct {
+    // Memory is the most memory the process tree may use in bytes, it is killed by the OOM killer beyond it.
+    Memory int64
+    // CPU is the CPU time the process tree may use in CPUs, e.g. 1.5 for one and a half CPUs.
+    CPU float64
+    // Pids is the most processes and threads the process tree may have.
+    Pids int
+}
+
+// WithCgroupLimits applies the limits to the cgroup of WithCgroup.
+// It is only supported on Linux.
+func WithCgroupLimits(limits CgroupLimits) Option {
+    return func(o *options) { o.cgroupLimits = &limits }
+}
+
+// WithCgroupUsage emits a CgroupUsageMessage with the resource usage of the cgroup of WithCgroup every interval while the process runs.
+// It is only supported on Linux.
+func WithCgroupUsage(interval time.Duration) Option {
+    return func(o *options) { o.cgroupUsage = interval }
+}
+
+// WithRlimit limits the resource of the child, e.g. syscall.RLIMIT_CPU or syscall.RLIMIT_AS, to the soft and hard limits.
+// The limits are set while the child is stopped at the exec of its program, so the program never runs without them.
+// The child is traced until then, a setuid program therefore runs without its privileges.
+// A LimitExceededMessage is emitted before the exit when the child is killed by exceeding its CPU or file size limit.
+// It is only supported on Linux.
+func WithRlimit(resource int, soft, hard uint64) Option {
+    return func(o *options) { o.rlimits = append(o.rlimits, rlimit{resource: resource, soft: soft, hard: hard}) }
+}
+
+type rlimit struct {
+    resource   int
+    soft, hard uint64
+}
+
+// WithStdinTee mirrors everything written to the child's stdin to the writers, independent of StdinMessages.
+// A failing writer does not affect the child, the first error is returned with the result of the command.
+func WithStdinTee(w ...io.Writer) Option {
+    return func(o *options) { o.stdinTee = append(o.stdinTee, w...) }
+}
+
+// WithStdoutFile writes the stdout of the child to the file f.Path, in addition to or instead of StdioMessages.
+func WithStdoutFile(f OutputFile) Option {
+    return func(o *options) { o.stdoutFile = &f }
+}
+
+// WithStderrFile writes the stderr of the child to the file f.Path, in addition to or instead of StdioMessages.
+func WithStderrFile(f OutputFile) Option {
+    return func(o *options) { o.stderrFile = &f }
+}
+
+// WithHistory keeps the last n messages of a command started with New, so listeners can replay them with WithReplay.
+func WithHistory(n int) Option {
+    return func(o *options) { o.history = n }
+}
+
+// WithStdinReader feeds everything read from r to the stdin of the command.
+// Multiple readers are read one after another, for Run they follow its stdin.
+// For New the data is pushed as it is read, so it may be interleaved with inputs pushed meanwhile.
+func WithStdinReader(r io.Reader) Option {
+    return func(o *options) { o.stdinReaders = append(o.stdinReaders, r) }
+}
+
+// WithBufferedOutput keeps every message of a command started with New,
+// so every listener and Cmd.Read receive them from the start, no matter when they were created.
+// The messages are kept as long as the command, an explicit WithHistory keeps and replays only the most recent ones instead.
+func WithBufferedOutput() Option {
+    return func(o *options) { o.bufferedOutput = true }
+}
+
+// WithCompaction merges contiguous stdio messages of the same kind kept by WithHistory or WithBufferedOutput into messages of up to size bytes,
+// so a history of a given number of messages holds more output. A merged message has the time of its first message and Until of its last one.
+// Listeners only receive merged messages when they are replayed.
+func WithCompaction(size int) Option {
+    return func(o *options) { o.compact = size }
+}
+
+// WithFirstOutputMessage emits a FirstOutputMessage right before the first output of the command on stdout or stderr.
+func WithFirstOutputMessage() Option {
+    return func(o *options) { o.firstOutput = true }
+}
+
+// WithDeadline kills a command started with New once its process ran for d, independent of its context.
+// A TimeoutMessage is emitted before the exit, whose reason is ExitDeadline by ActorSubflow.
+func WithDeadline(d time.Duration) Option {
+    return func(o *options) { o.deadline = d }
+}
+
+// WithInputQuota limits the total size of the inputs pushed to a command started with New to quota bytes, including the inputs of WithStdinReader.
+// A push exceeding it fails with ErrInputQuota and so does every push after it, the first one emits an InputQuotaMessage.
+// A quota of 0 or less is unlimited.
+func WithInputQuota(quota int64) Option {
+    return func(o *options) { o.inputQuota = quota }
+}
+
+// WithIdleTimeout kills a command started with New once its process did not write to stdout or stderr for d.
+// An IdleTimeoutMessage is emitted before the exit, whose reason is ExitWatchdog by ActorSubflow.
+func WithIdleTimeout(d time.Duration) Option {
+    return func(o *options) { o.idleTimeout = d }
+}
+
+// WithFatalPatterns watches every line a command started with New writes to stdout or stderr for the patterns,
+// emitting a FatalIndicationMessage for each line matching one of them as soon as it was written,
+// without waiting for the process to exit or hang.
+func WithFatalPatterns(patterns ...FatalPattern) Option {
+    return func(o *options) { o.fatalPatterns = append(o.fatalPatterns, patterns...) }
+}
+
+// WithPrompts answers the prompts of a command started with New for secrets, e.g. PasswordPrompt,
+// writing the secret returned for a prompt to stdin with NewSecretInput.
+// It only works for programs reading the secret from stdin, like sudo -S,
+// programs reading it from their terminal, like ssh or gpg, need a pseudo-terminal which subflow does not provide.
+func WithPrompts(prompts ...Prompt) Option {
+    return func(o *options) { o.prompts = append(o.prompts, prompts...) }
+}
+
+// InputFilter validates an input pushed to a command started with New before it is queued.
+// It returns the input to write, in itself or a rewritten one, or an error to reject it.
+type InputFilter func(in Input) (Input, error)
+
+// WithInputFilter runs the filters in order on every input pushed to a command started with New, including the inputs of WithStdinReader.
+// When a filter rejects an input, the push fails with an error wrapping ErrInputRejected, none of its inputs are queued,
+// and an InputRejectedMessage is emitted for the rejected input.
+func WithInputFilter(filters ...InputFilter) Option {
+    return func(o *options) { o.inputFilters = append(o.inputFilters, filters...) }
+}
+
+// WithInputLimit limits the inputs queued for the stdin of a command started with New to n inputs and size bytes,
+// a limit of 0 or less is unlimited. Cmd.PushCtx blocks until new inputs fit, Cmd.PushErr returns ErrQueueFull.
+func WithInputLimit(n int, size int64) Option {
+    return func(o *options) {
+        o.inputLimit = n
+        o.inputByteLimit = size
+    }
+}
+
+// WithSink delivers every message of a command started with New to the sinks.
+// The sinks are flushed and closed after the final ExitMessage, before Done is closed and Close returns.
+func WithSink(sinks ...Sink) Option {
+    return func(o *options) { o.sinks = append(o.sinks, sinks...) }
+}
+
+// WithSinkRetry retries a failing Sink.Write of the message up to retries times, waiting the delays of backoff in between.
+// Meanwhile the following messages are buffered for the sink. A nil backoff retries right away.
+func WithSinkRetry(retries int, backoff Backoff) Option {
+    return func(o *options) {
+        o.sinkRetries = retries
+        o.sinkBackoff = backoff
+    }
+}
+
+// WithFlushTimeout limits how long a command waits for its sinks to be flushed and closed after it exits,
+// returning ErrFlushTimeout if they take longer. By default it waits indefinitely.
+func WithFlushTimeout(timeout time.Duration) Option {
+    return func(o *options) { o.flushTimeout = timeout }
+}
+
+// WithNamedPipes connects the child's stdin and stdout through the named pipes (FIFOs) at the paths instead of anonymous pipes,
+// so other processes on the host can write to its stdin or read from its stdout too. Readers of a pipe compete for its data.
+// The pipes are created if they do not exist and an empty path keeps an anonymous pipe for that stream.
+// It is only supported on Unix.
+func WithNamedPipes(stdin, stdout string) Option {
+    return func(o *options) {
+        o.stdinPipe = stdin
+        o.stdoutPipe = stdout
+    }
+}
+
+// linuxOnly returns the name of an option set on o which is only supported on Linux, or an empty string.
+func (o *options) linuxOnly() string {
+    switch {
+    case o.seLinuxLabel != "":
+        return "SELinux label"
+    case o.appArmorProfile != "":
+        return "AppArmor profile"
+    case len(o.ambientCaps) > 0:
+        return "ambient capabilities"
+    case o.restrictCaps:
+        return "capability bounding set"
+    case o.noNewPrivs:
+        return "no_new_privs"
+    case o.noNetwork:
+        return "network isolation"
+    case o.deathSignal != nil:
+        return "parent death signal"
+    case o.privateTmp:
+        return "private /tmp"
+    case o.privateHome:
+        return "private home"
+    case o.readOnlyRoot:
+        return "read-only root"
+    case o.workspaceQuota > 0:
+        return "workspace quota"
+    case o.cgroup != "" || o.cgroupLimits != nil || o.cgroupUsage > 0:
+        return "cgroup"
+    case len(o.rlimits) > 0:
+        return "resource limits"
+    case o.usage > 0:
+        return "usage messages"
+    }
+    return ""
+}
+
+// tee returns a writer for the stdin tee, or nil if there is none.
+func (o *options) tee() *teeWriter {
+    if len(o.stdinTee) == 0 {
+        return nil
+    }
+    return o.newTeeWriter("stdin tee", io.MultiWriter(o.stdinTee...))
+}
+
+// newTeeWriter returns a teeWriter for w, reporting its first error with writeError as coming from source.
+func (o *options) newTeeWriter(source string, w io.Writer) *teeWriter {
+    tw := &teeWriter{w: w}
+    if report := o.writeError; report != nil {
+        tw.onErr = func(err error) { report(source, err) }
+    }
+    return tw
+}
+
+// teeWriter mirrors writes to w, it keeps the first error and drops every write after it.
+type teeWriter struct {
+    w     io.Writer
+    onErr func(error)
+    lock  sync.Mutex
+    err   error
+}
+
+func (tw *teeWriter) Write(b []byte) (int, error) {
+    tw.lock.Lock()
+    defer tw.lock.Unlock()
+    if tw.err == nil {
+        if _, tw.err = tw.w.Write(b); tw.err != nil && tw.onErr != nil {
+            tw.onErr(tw.err)
+        }
+    }
+    return len(b), nil
+}
+
+// Err returns the first error of the underlying writer.
+func (tw *teeWriter) Err() error {
+    if tw == nil {
+        return nil
+    }
+    tw.lock.Lock()
+    defer tw.lock.Unlock()
+    return tw.err
+}
+
+// resources holds what was allocated for a single run of a command.
+type resources struct {
+    // cgroup is the cgroup directory of the child, if any.
+    cgroup string
+    // runID is the id of the run set by WithRunEnv, if any.
+    runID string
+    // extraFds are the file descriptors of the files of WithExtraFiles in the child.
+    extraFds []int
+    // stdin replaces the stdin pipe of the child, if set.
+    stdin io.WriteCloser
+    // drain finishes reading output after the child exits, before its result is reported.
+    drain   []func() error
+    cleanup []func() error
+}
+
+// drainOutput finishes reading the output of the child.
+func (res *resources) drainOutput() (err error) {
+    for _, fn := range res.drain {
+        err = errors.Join(err, fn())
+    }
+    res.drain = nil
+    return err
+}
+
+// release frees the resources in the reverse order they were allocated.
+func (res *resources) release() (err error) {
+    for _, fn := range slices.Backward(res.cleanup) {
+        err = errors.Join(err, fn())
+    }
+    res.cleanup = nil
+    return err
+}
+
+// apply applies the options to c before it is started.
+// The returned resources must be released once c has exited.
+func (o *options) apply(c *exec.Cmd) (*resources, error) {
+    res := new(resources)
+    if o.sysProcAttr != nil {
+        attr := *o.sysProcAttr
+        c.SysProcAttr = &attr
+    }
+    // Files already set on a wrapped exec.Cmd keep their descriptors.
+    for i := range o.extraFiles {
+        res.extraFds = append(res.extraFds, 3+len(c.ExtraFiles)+i)
+    }
+    c.ExtraFiles = append(c.ExtraFiles, o.extraFiles...)
+    if o.runEnv {
+        res.runID = newRunID()
+        if c.Env == nil {
+            c.Env = os.Environ()
+        }
+        c.Env = append(c.Env, "SUBFLOW_RUN_ID="+res.runID, "SUBFLOW_ATTEMPT="+strconv.Itoa(max(o.attempt, 1)), "SUBFLOW_CMD_NAME="+c.Args[0])
+    }
+    if err := o.openParentPipe(c, res); err != nil {
+        return nil, errors.Join(err, res.release())
+    }
+    if cred := o.credential; cred != nil {
+        if err := setCredential(c, cred.uid, cred.gid, cred.groups); err != nil {
+            return nil, errors.Join(err, res.release())
+        }
+    }
+    if o.workspace {
+        dir, err := os.MkdirTemp("", "subflow-")
+        if err != nil {
+            return nil, errors.Join(fmt.Errorf("create workspace: %w", err), res.release())
+        }
+        res.cleanup = append(res.cleanup, func() error { return os.RemoveAll(dir) })
+        if cred := o.credential; cred != nil {
+            if err := os.Chown(dir, int(cred.uid), int(cred.gid)); err != nil {
+                return nil, errors.Join(fmt.Errorf("chown workspace: %w", err), res.release())
+            }
+        }
+        c.Dir = dir
+        if c.Env == nil {
+            c.Env = os.Environ()
+        }
+        c.Env = append(c.Env, "TMPDIR="+dir, "TMP="+dir, "TEMP="+dir)
+    }
+
+    if err := o.outputFiles(c, res); err != nil {
+        return nil, errors.Join(err, res.release())
+    } else if err := o.namedPipes(c, res); err != nil {
+        return nil, errors.Join(err, res.release())
+    } else if err := o.setProcessGroup(c); err != nil {
+        return nil, errors.Join(err, res.release())
+    } else if err := o.prepare(c, res); err != nil {
+        return nil, errors.Join(err, res.release())
+    }
+    return res, nil
+}
+
+// launch checks the required ports and starts c.
+func (o *options) launch(c *exec.Cmd) error {
+    if err := o.checkPorts(); err != nil {
+        return err
+    }
+    return o.start(c)
+}
+
+// run starts c and waits for it to exit.
+func (o *options) run(c *exec.Cmd) error {
+    if err := o.launch(c); err != nil {
+        return err
+    }
+    return c.Wait()
+}
+
+// lookupEnv returns the last value of key in env, a nil env is the environment of the current process.
+func lookupEnv(env []string, key string) (string, bool) {
+    if env == nil {
+        return os.LookupEnv(key)
+    }
+    for _, kv := range slices.Backward(env) {
+        if k, v, ok := strings.Cut(kv, "="); ok && k == key {
+            return v, true
+        }
+    }
+    return "", false
+}
+
+// sysProcAttr returns the SysProcAttr of c, allocating it if needed.
+func sysProcAttr(c *exec.Cmd) *syscall.SysProcAttr {
+    if c.SysProcAttr == nil {
+        c.SysProcAttr = new(syscall.SysProcAttr)
+    }
+    return c.SysProcAttr
+}
+
+// unsupported reports an option that can not be applied on the current platform.
+func unsupported(option string) error {
+    return fmt.Errorf("%s is not supported on %s: %w", option, runtime.GOOS, errors.ErrUnsupported)
+}
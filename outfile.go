@@ -0,0 +1,51 @@
+package subflow
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "os/exec"
+)
+
+// OutputFile is a file receiving an output stream of the child, see WithStdoutFile and WithStderrFile.
+type OutputFile struct {
+    Path string
+    // Append appends to an existing file instead of truncating it.
+    Append bool
+    // Only writes the stream only to the file, without StdioMessages or other writers for it.
+    // The child writes to the file directly, so the output is not seen by e.g. WithIdleTimeout or WithFatalPatterns either.
+    Only bool
+}
+
+// outputFiles opens the files of WithStdoutFile and WithStderrFile, and connects them to c.
+func (o *options) outputFiles(c *exec.Cmd, res *resources) (err error) {
+    if c.Stdout, err = o.stdoutFile.open(o, "stdout file", c.Stdout, res); err != nil {
+        return fmt.Errorf("stdout file: %w", err)
+    } else if c.Stderr, err = o.stderrFile.open(o, "stderr file", c.Stderr, res); err != nil {
+        return fmt.Errorf("stderr file: %w", err)
+    }
+    return nil
+}
+
+// open opens the file and returns the writer for the stream currently written to w.
+// A failing file does not affect the other writers, the first error is returned when res is released.
+func (f *OutputFile) open(o *options, source string, w io.Writer, res *resources) (io.Writer, error) {
+    if f == nil {
+        return w, nil
+    }
+    flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+    if f.Append {
+        flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+    }
+    file, err := os.OpenFile(f.Path, flag, 0o666)
+    if err != nil {
+        return nil, err
+    } else if f.Only {
+        res.cleanup = append(res.cleanup, file.Close)
+        return file, nil
+    }
+    tw := o.newTeeWriter(source, file)
+    res.cleanup = append(res.cleanup, func() error { return errors.Join(tw.Err(), file.Close()) })
+    return io.MultiWriter(tw, w), nil
+}
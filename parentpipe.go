@@ -0,0 +1,26 @@
+package subflow
+
+import (
+    "fmt"
+    "os"
+    "os/exec"
+    "strconv"
+)
+
+// openParentPipe passes the read end of a pipe for WithParentPipe to c, the write end is kept open until c has exited.
+func (o *options) openParentPipe(c *exec.Cmd, res *resources) error {
+    if !o.parentPipe {
+        return nil
+    }
+    r, w, err := os.Pipe()
+    if err != nil {
+        return fmt.Errorf("parent pipe: %w", err)
+    }
+    res.cleanup = append(res.cleanup, r.Close, w.Close)
+    c.ExtraFiles = append(c.ExtraFiles, r)
+    if c.Env == nil {
+        c.Env = os.Environ()
+    }
+    c.Env = append(c.Env, "SUBFLOW_PARENT_FD="+strconv.Itoa(2+len(c.ExtraFiles)))
+    return nil
+}
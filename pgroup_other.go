@@ -0,0 +1,19 @@
+//go:build !unix
+
+package subflow
+
+import (
+    "os"
+    "os/exec"
+)
+
+// setProcessGroup starts c in a new process group with WithProcessGroup.
+func (o *options) setProcessGroup(*exec.Cmd) error {
+    if o.processGroup {
+        return unsupported("process group")
+    }
+    return nil
+}
+
+// signal sends sig to the process p.
+func (o *options) signal(p *os.Process, sig os.Signal) error { return p.Signal(sig) }
@@ -0,0 +1,32 @@
+//go:build unix
+
+package subflow
+
+import (
+    "errors"
+    "os"
+    "os/exec"
+    "syscall"
+)
+
+// setProcessGroup starts c in a new process group with WithProcessGroup.
+func (o *options) setProcessGroup(c *exec.Cmd) error {
+    if o.processGroup {
+        sysProcAttr(c).Setpgid = true
+    }
+    return nil
+}
+
+// signal sends sig to the process p, or to its whole process group with WithProcessGroup.
+func (o *options) signal(p *os.Process, sig os.Signal) error {
+    s, ok := sig.(syscall.Signal)
+    if !o.processGroup || !ok {
+        return p.Signal(sig)
+    }
+    if err := syscall.Kill(-p.Pid, s); errors.Is(err, syscall.ESRCH) {
+        return os.ErrProcessDone
+    } else if err != nil {
+        return os.NewSyscallError("kill", err)
+    }
+    return nil
+}
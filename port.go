@@ -0,0 +1,29 @@
+package subflow
+
+import (
+    "fmt"
+    "net"
+)
+
+// PortInUseError is returned when an address of WithRequiredPorts can not be listened on before the command starts.
+type PortInUseError struct {
+    Addr string
+    Err  error
+}
+
+func (e *PortInUseError) Error() string { return fmt.Sprintf("port %s in use: %v", e.Addr, e.Err) }
+
+func (e *PortInUseError) Unwrap() error { return e.Err }
+
+// checkPorts returns a *PortInUseError for the first address of WithRequiredPorts which is not free.
+// The check releases the addresses again, so another process may still take them before the child binds them.
+func (o *options) checkPorts() error {
+    for _, addr := range o.requiredPorts {
+        l, err := net.Listen("tcp", addr)
+        if err != nil {
+            return &PortInUseError{Addr: addr, Err: err}
+        }
+        _ = l.Close()
+    }
+    return nil
+}
@@ -0,0 +1,110 @@
+package subflow
+
+import (
+    "fmt"
+    "io"
+    "sync"
+)
+
+// prefixColors are the ANSI colors of the names, cycled through in the order the sinks are created.
+var prefixColors = []string{"36", "33", "32", "35", "34", "31"}
+
+// PrefixOutput writes the output of several commands to w, procfile-runner style.
+// Every line is prefixed with the name of its command, padded to the longest name so the output is aligned,
+// and the start and exit of every command are written as status lines.
+//
+//	web    | listening on :8080
+//	worker | started with pid 4242
+//	worker | exited with code 1 (normal)
+type PrefixOutput struct {
+    w     io.Writer
+    color bool
+    lock  sync.Mutex
+    width int
+    n     int
+    err   error
+}
+
+// NewPrefixOutput returns a PrefixOutput writing to w, with colored names if color is set.
+func NewPrefixOutput(w io.Writer, color bool) *PrefixOutput {
+    return &PrefixOutput{w: w, color: color}
+}
+
+// Sink returns a Sink writing the output of the command named name, to be registered with WithSink.
+// Names of sinks created later widen the prefix of all following lines.
+func (p *PrefixOutput) Sink(name string) Sink {
+    p.lock.Lock()
+    defer p.lock.Unlock()
+    ps := &prefixSink{p: p, name: name}
+    if p.color {
+        ps.color = prefixColors[p.n%len(prefixColors)]
+    }
+    p.n++
+    p.width = max(p.width, len(name))
+    return ps
+}
+
+// printf writes a line of the command named name with the color.
+func (p *PrefixOutput) printf(name, color, format string, a ...any) error {
+    p.lock.Lock()
+    defer p.lock.Unlock()
+    if p.err != nil {
+        return p.err
+    }
+    prefix := fmt.Sprintf("%-*s |", p.width, name)
+    if color != "" {
+        prefix = "\x1b[" + color + "m" + prefix + "\x1b[0m"
+    }
+    // The prefix is an argument, a name containing % must not be read as a verb.
+    _, p.err = fmt.Fprintf(p.w, "%s "+format, append([]any{prefix}, a...)...)
+    return p.err
+}
+
+var _ Sink = (*prefixSink)(nil)
+
+// prefixSink is the Sink of a single command of a PrefixOutput.
+type prefixSink struct {
+    p     *PrefixOutput
+    name  string
+    color string
+    lines lineDecoder
+    err   error
+}
+
+func (ps *prefixSink) Write(msg Message) error {
+    ps.lines.decode(msg, ps.line)
+    return ps.err
+}
+
+// Close writes the incomplete lines left, it does not close the underlying writer.
+func (ps *prefixSink) Close() error {
+    ps.lines.flush(ps.line)
+    return ps.err
+}
+
+func (ps *prefixSink) line(msg Message) bool {
+    if ps.err != nil {
+        return false
+    }
+    switch msg := msg.(type) {
+    case StartMessage:
+        if msg.Pid != 0 {
+            ps.err = ps.p.printf(ps.name, ps.color, "started with pid %d\n", msg.Pid)
+        }
+    case StdoutMessage:
+        ps.err = ps.output(msg.Data)
+    case StderrMessage:
+        ps.err = ps.output(msg.Data)
+    case ExitMessage:
+        ps.err = ps.p.printf(ps.name, ps.color, "exited with code %d (%s)\n", msg.Code, msg.Reason)
+    }
+    return ps.err == nil
+}
+
+// output writes a line of output, completing an incomplete last line.
+func (ps *prefixSink) output(line []byte) error {
+    if len(line) > 0 && line[len(line)-1] != '\n' {
+        return ps.p.printf(ps.name, ps.color, "%s\n", line)
+    }
+    return ps.p.printf(ps.name, ps.color, "%s", line)
+}
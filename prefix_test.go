@@ -0,0 +1,26 @@
+package subflow
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestPrefixOutputNameWithPercent(t *testing.T) {
+    var b strings.Builder
+    p := NewPrefixOutput(&b, false)
+    sink := p.Sink("100%d")
+    if err := sink.Write(NewStdioMessage[StdoutMessage]("hello\n")); err != nil {
+        t.Fatal(err)
+    }
+    if err := sink.Write(NewStartPidMessage(42)); err != nil {
+        t.Fatal(err)
+    }
+    if err := sink.Close(); err != nil {
+        t.Fatal(err)
+    }
+
+    want := "100%d | hello\n100%d | started with pid 42\n"
+    if got := b.String(); got != want {
+        t.Errorf("output = %q, want %q", got, want)
+    }
+}
@@ -0,0 +1,507 @@
+//go:build linux
+
+package subflow
+
+import (
+    "errors"
+    "fmt"
+    "io/fs"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "runtime"
+    "slices"
+    "strconv"
+    "strings"
+    "syscall"
+    "time"
+    "unsafe"
+)
+
+// prepare applies the options to c before it is started.
+func (o *options) prepare(c *exec.Cmd, res *resources) error {
+    if o.cmdLine != nil {
+        return unsupported("command line")
+    }
+    if len(o.ambientCaps) > 0 {
+        attr := sysProcAttr(c)
+        attr.AmbientCaps = append(attr.AmbientCaps, o.ambientCaps...)
+    }
+    if o.deathSignal != nil {
+        sig, ok := o.deathSignal.(syscall.Signal)
+        if !ok {
+            return fmt.Errorf("parent death signal %v is not a syscall.Signal", o.deathSignal)
+        }
+        sysProcAttr(c).Pdeathsig = sig
+    }
+    if o.cgroup != "" {
+        if err := createCgroup(c, res, o.cgroup, o.cgroupLimits); err != nil {
+            return err
+        }
+    } else if o.cgroupLimits != nil || o.cgroupUsage > 0 {
+        return fmt.Errorf("cgroup limits and usage: %w", ErrNoCgroup)
+    }
+    return nil
+}
+
+// createCgroup creates a new cgroup with the limits inside parent and sets up c to be started in it.
+func createCgroup(c *exec.Cmd, res *resources, parent string, limits *CgroupLimits) error {
+    dir, err := os.MkdirTemp(parent, "subflow-")
+    if err != nil {
+        return fmt.Errorf("create cgroup: %w", err)
+    }
+    res.cgroup = dir
+    res.cleanup = append(res.cleanup, func() error { return removeCgroup(dir) })
+    if limits != nil {
+        if err := setCgroupLimits(dir, *limits); err != nil {
+            return err
+        }
+    }
+
+    f, err := os.Open(dir)
+    if err != nil {
+        return fmt.Errorf("open cgroup: %w", err)
+    }
+    res.cleanup = append(res.cleanup, f.Close)
+    attr := sysProcAttr(c)
+    attr.UseCgroupFD = true
+    attr.CgroupFD = int(f.Fd())
+    return nil
+}
+
+// cpuPeriod is the period of the CPU limit of a cgroup in microseconds.
+const cpuPeriod = 100000
+
+// setCgroupLimits writes the limits to the interface files of the cgroup dir.
+func setCgroupLimits(dir string, limits CgroupLimits) error {
+    var files [][2]string
+    if limits.Memory > 0 {
+        files = append(files, [2]string{"memory.max", strconv.FormatInt(limits.Memory, 10)})
+    }
+    if limits.CPU > 0 {
+        files = append(files, [2]string{"cpu.max", fmt.Sprintf("%d %d", max(int64(limits.CPU*cpuPeriod), 1000), cpuPeriod)})
+    }
+    if limits.Pids > 0 {
+        files = append(files, [2]string{"pids.max", strconv.Itoa(limits.Pids)})
+    }
+    for _, f := range files {
+        if err := os.WriteFile(filepath.Join(dir, f[0]), []byte(f[1]), 0); err != nil {
+            return fmt.Errorf("set cgroup limit: %w", err)
+        }
+    }
+    return nil
+}
+
+// removeCgroup kills any process left in the cgroup dir and removes it.
+func removeCgroup(dir string) error {
+    err := syscall.Rmdir(dir)
+    if !errors.Is(err, syscall.EBUSY) {
+        return err
+    } else if err := os.WriteFile(filepath.Join(dir, "cgroup.kill"), []byte("1"), 0); err != nil {
+        return fmt.Errorf("kill cgroup: %w", err)
+    }
+
+    // Killed processes leave the cgroup asynchronously.
+    for range 100 {
+        if err = syscall.Rmdir(dir); !errors.Is(err, syscall.EBUSY) {
+            return err
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+    return fmt.Errorf("remove cgroup: %w", err)
+}
+
+// start starts c with its resource limits.
+func (o *options) start(c *exec.Cmd) error {
+    return o.startThread(c)
+}
+
+// startThread starts c.
+// Settings Linux keeps per thread are applied to a dedicated OS thread which then forks the child,
+// the child inherits them while the rest of the parent process is left untouched.
+// Resource limits are set while the child is stopped at the exec of its program, which requires the thread to trace it.
+func (o *options) startThread(c *exec.Cmd) error {
+    setup := o.threadSetup(c)
+    if len(setup) == 0 && len(o.rlimits) == 0 {
+        return c.Start()
+    }
+
+    errc := make(chan error, 1)
+    go func() {
+        // The thread is never unlocked, so the runtime discards it once this goroutine exits.
+        runtime.LockOSThread()
+        for _, fn := range setup {
+            if err := fn(); err != nil {
+                errc <- err
+                return
+            }
+        }
+        if len(o.rlimits) > 0 {
+            sysProcAttr(c).Ptrace = true
+        }
+        err := c.Start()
+        if err == nil && len(o.rlimits) > 0 {
+            if err = limitTraced(c.Process.Pid, o.rlimits); err != nil {
+                // Do not leave a process behind that the caller will never wait for.
+                _ = c.Process.Kill()
+                err = errors.Join(err, c.Wait())
+            }
+        }
+        errc <- err
+        if err == nil && o.deathSignal != nil {
+            // The parent death signal is sent once the forking thread exits, not the process, so keep it until the child exited.
+            waitExited(c.Process.Pid)
+        }
+    }()
+    return <-errc
+}
+
+// limitTraced waits for the traced child pid to stop at the exec of its program, sets its resource limits and detaches from it,
+// so the program never runs without them. It must be called on the thread which started the child.
+func limitTraced(pid int, limits []rlimit) error {
+    var ws syscall.WaitStatus
+    for {
+        _, err := syscall.Wait4(pid, &ws, syscall.WALL, nil)
+        if err == nil {
+            break
+        } else if err != syscall.EINTR {
+            return fmt.Errorf("wait for exec: %w", err)
+        }
+    }
+    if !ws.Stopped() {
+        return fmt.Errorf("wait for exec: child did not stop, status %#x", ws)
+    } else if err := setRlimits(pid, limits); err != nil {
+        return err
+    } else if err := syscall.PtraceDetach(pid); err != nil {
+        return fmt.Errorf("detach from child: %w", err)
+    }
+    return nil
+}
+
+const (
+    pPid        = 1
+    wExited     = 0x4
+    wNoWait     = 0x1000000
+    siginfoSize = 128
+)
+
+// waitExited waits until the child pid exited, without reaping it.
+func waitExited(pid int) {
+    var info [siginfoSize]byte
+    for {
+        _, _, errno := syscall.Syscall6(syscall.SYS_WAITID, pPid, uintptr(pid), uintptr(unsafe.Pointer(&info)), wExited|wNoWait, 0, 0)
+        if errno != syscall.EINTR {
+            return
+        }
+    }
+}
+
+// threadSetup returns the functions that prepare the forking thread.
+func (o *options) threadSetup(c *exec.Cmd) (setup []func() error) {
+    if o.umask != nil {
+        mask := int(o.umask.Perm())
+        setup = append(setup, func() error {
+            // Detach the filesystem attributes from the rest of the process so the umask only applies to this thread.
+            if err := syscall.Unshare(syscall.CLONE_FS); err != nil {
+                return fmt.Errorf("unshare fs: %w", err)
+            }
+            syscall.Umask(mask)
+            return nil
+        })
+    }
+    if o.seLinuxLabel != "" {
+        setup = append(setup, func() error { return setExecAttr("", o.seLinuxLabel) })
+    }
+    if o.appArmorProfile != "" {
+        setup = append(setup, func() error {
+            // Kernels with LSM stacking expose AppArmor's attributes in their own directory.
+            err := setExecAttr("apparmor", "exec "+o.appArmorProfile)
+            if errors.Is(err, fs.ErrNotExist) {
+                err = setExecAttr("", "exec "+o.appArmorProfile)
+            }
+            return err
+        })
+    }
+    if o.restrictCaps {
+        setup = append(setup, func() error { return restrictBoundingCaps(o.boundingCaps) })
+    }
+    if o.noNewPrivs {
+        setup = append(setup, func() error {
+            if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0); errno != 0 {
+                return fmt.Errorf("set no_new_privs: %w", errno)
+            }
+            return nil
+        })
+    }
+    if o.noNetwork {
+        setup = append(setup, func() error {
+            if err := syscall.Unshare(syscall.CLONE_NEWNET); err != nil {
+                return fmt.Errorf("unshare network: %w", err)
+            }
+            return loopbackUp()
+        })
+    }
+    if len(o.cpuAffinity) > 0 {
+        setup = append(setup, func() error { return setAffinity(o.cpuAffinity) })
+    }
+    if o.nice != nil {
+        setup = append(setup, func() error {
+            // Linux keeps the nice value per thread, so only the forking thread and the child get it.
+            if err := syscall.Setpriority(syscall.PRIO_PROCESS, 0, *o.nice); err != nil {
+                return fmt.Errorf("set nice value: %w", err)
+            }
+            return nil
+        })
+    }
+    if o.privateTmp || o.privateHome || o.readOnlyRoot || o.workspaceQuota > 0 {
+        setup = append(setup, func() error { return o.setupMounts(c) })
+    }
+    return
+}
+
+const (
+    prCapBSetDrop   = 24
+    prSetNoNewPrivs = 38
+)
+
+// restrictBoundingCaps drops every capability not in keep from the bounding set of the calling thread.
+func restrictBoundingCaps(keep []uintptr) error {
+    b, err := os.ReadFile("/proc/sys/kernel/cap_last_cap")
+    if err != nil {
+        return err
+    }
+    last, err := strconv.Atoi(strings.TrimSpace(string(b)))
+    if err != nil {
+        return fmt.Errorf("parse last capability: %w", err)
+    }
+
+    for c := uintptr(0); c <= uintptr(last); c++ {
+        if slices.Contains(keep, c) {
+            continue
+        }
+        if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prCapBSetDrop, c, 0); errno != 0 {
+            return fmt.Errorf("drop capability %d: %w", c, errno)
+        }
+    }
+    return nil
+}
+
+// ifreqFlags is the layout of struct ifreq used to get and set interface flags.
+type ifreqFlags struct {
+    name  [syscall.IFNAMSIZ]byte
+    flags uint16
+    _     [22]byte
+}
+
+// loopbackUp brings up the loopback interface of the calling thread's network namespace,
+// a new namespace starts with it down.
+func loopbackUp() error {
+    fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+    if err != nil {
+        return fmt.Errorf("loopback socket: %w", err)
+    }
+    defer syscall.Close(fd)
+
+    var req ifreqFlags
+    copy(req.name[:], "lo")
+    if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCGIFFLAGS, uintptr(unsafe.Pointer(&req))); errno != 0 {
+        return fmt.Errorf("get loopback flags: %w", errno)
+    }
+    req.flags |= syscall.IFF_UP
+    if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCSIFFLAGS, uintptr(unsafe.Pointer(&req))); errno != 0 {
+        return fmt.Errorf("set loopback flags: %w", errno)
+    }
+    return nil
+}
+
+// setAffinity pins the calling thread to cpus.
+func setAffinity(cpus []int) error {
+    var mask []uint64
+    for _, cpu := range cpus {
+        if cpu < 0 {
+            return fmt.Errorf("invalid cpu %d", cpu)
+        }
+        for len(mask) <= cpu/64 {
+            mask = append(mask, 0)
+        }
+        mask[cpu/64] |= 1 << (cpu % 64)
+    }
+    if _, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, 0, uintptr(len(mask)*8), uintptr(unsafe.Pointer(&mask[0]))); errno != 0 {
+        return fmt.Errorf("set cpu affinity: %w", errno)
+    }
+    return nil
+}
+
+// childOwner returns the user and group the child of c runs as, those of WithCredential or else the current ones.
+func childOwner(c *exec.Cmd) (uid, gid int) {
+    if c.SysProcAttr != nil && c.SysProcAttr.Credential != nil {
+        return int(c.SysProcAttr.Credential.Uid), int(c.SysProcAttr.Credential.Gid)
+    }
+    return os.Getuid(), os.Getgid()
+}
+
+// setupMounts moves the calling thread into a new mount namespace and applies the mount options to it.
+func (o *options) setupMounts(c *exec.Cmd) error {
+    if err := syscall.Unshare(syscall.CLONE_NEWNS); err != nil {
+        return fmt.Errorf("unshare mounts: %w", err)
+    }
+    // Keep receiving mounts from the parent namespace, but never propagate ours back to it.
+    if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_SLAVE, ""); err != nil {
+        return fmt.Errorf("make mounts private: %w", err)
+    }
+
+    uid, gid := childOwner(c)
+    writable := slices.Clone(o.writable)
+    // The private /tmp goes first, a workspace created in /tmp is mounted inside of it.
+    if o.privateTmp {
+        if err := mountTmpfs("/tmp", "mode=1777"); err != nil {
+            return err
+        }
+        writable = append(writable, "/tmp")
+        if o.workspace && within(c.Dir, "/tmp") {
+            if err := recreateWorkspace(c.Dir, uid, gid); err != nil {
+                return err
+            }
+        }
+    }
+    if o.workspaceQuota > 0 {
+        if err := mountTmpfs(c.Dir, fmt.Sprintf("size=%d,mode=0700,uid=%d,gid=%d", o.workspaceQuota, uid, gid)); err != nil {
+            return err
+        }
+        writable = append(writable, c.Dir)
+    }
+    if o.privateHome {
+        home, ok := lookupEnv(c.Env, "HOME")
+        if !ok {
+            return errors.New("private home: HOME is not set")
+        }
+        if err := mountTmpfs(home, fmt.Sprintf("mode=0700,uid=%d,gid=%d", uid, gid)); err != nil {
+            return err
+        }
+        writable = append(writable, home)
+    }
+    if o.readOnlyRoot {
+        return remountReadOnly(writable)
+    }
+    return nil
+}
+
+// recreateWorkspace creates the workspace dir again in the private /tmp, which hides the one created in the /tmp of the parent.
+func recreateWorkspace(dir string, uid, gid int) error {
+    if err := os.MkdirAll(filepath.Dir(dir), 0o755); err != nil {
+        return fmt.Errorf("create workspace: %w", err)
+    } else if err := os.Mkdir(dir, 0o700); err != nil {
+        return fmt.Errorf("create workspace: %w", err)
+    } else if err := os.Chown(dir, uid, gid); err != nil {
+        return fmt.Errorf("chown workspace: %w", err)
+    }
+    return nil
+}
+
+// remountReadOnly makes every mount of the calling thread's namespace read-only,
+// except for the writable paths and the kernel filesystems.
+func remountReadOnly(writable []string) error {
+    for i, path := range writable {
+        path, err := filepath.Abs(path)
+        if err != nil {
+            return err
+        }
+        writable[i] = path
+        // Make the path a mount of its own, bind mounts copy the flags of their source so this must happen before the remount.
+        if err := syscall.Mount(path, path, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+            return fmt.Errorf("bind writable %s: %w", path, err)
+        }
+    }
+
+    mounts, err := readMountInfo()
+    if err != nil {
+        return err
+    }
+    keep := append(writable, "/proc", "/sys", "/dev")
+    for _, m := range mounts {
+        if slices.ContainsFunc(keep, func(dir string) bool { return within(m.target, dir) }) {
+            continue
+        }
+        if err := syscall.Mount("", m.target, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY|m.flags, ""); err != nil {
+            return fmt.Errorf("remount %s read-only: %w", m.target, err)
+        }
+    }
+    return nil
+}
+
+type mountInfo struct {
+    target string
+    flags  uintptr
+}
+
+// mountFlags maps the per mount options of mountinfo to the flags needed to keep them on a remount.
+var mountFlags = map[string]uintptr{
+    "nosuid":      syscall.MS_NOSUID,
+    "nodev":       syscall.MS_NODEV,
+    "noexec":      syscall.MS_NOEXEC,
+    "noatime":     syscall.MS_NOATIME,
+    "nodiratime":  syscall.MS_NODIRATIME,
+    "relatime":    syscall.MS_RELATIME,
+    "strictatime": syscall.MS_STRICTATIME,
+}
+
+// readMountInfo lists the mounts of the calling thread's mount namespace, see proc_pid_mountinfo(5).
+func readMountInfo() ([]mountInfo, error) {
+    b, err := os.ReadFile(fmt.Sprintf("/proc/self/task/%d/mountinfo", syscall.Gettid()))
+    if err != nil {
+        return nil, err
+    }
+
+    var mounts []mountInfo
+    for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+        fields := strings.Fields(line)
+        if len(fields) < 6 {
+            return nil, fmt.Errorf("invalid mountinfo line %q", line)
+        }
+        m := mountInfo{target: unescapeMountInfo(fields[4])}
+        for _, opt := range strings.Split(fields[5], ",") {
+            m.flags |= mountFlags[opt]
+        }
+        mounts = append(mounts, m)
+    }
+    return mounts, nil
+}
+
+// unescapeMountInfo replaces the octal escapes mountinfo uses for whitespace and backslashes.
+func unescapeMountInfo(s string) string {
+    var sb strings.Builder
+    for i := 0; i < len(s); i++ {
+        if s[i] == '\\' && i+3 < len(s) {
+            if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+                sb.WriteByte(byte(c))
+                i += 3
+                continue
+            }
+        }
+        sb.WriteByte(s[i])
+    }
+    return sb.String()
+}
+
+// within reports whether path is dir or inside of it.
+func within(path, dir string) bool {
+    return path == dir || dir == "/" || strings.HasPrefix(path, dir+"/")
+}
+
+func mountTmpfs(target, data string) error {
+    if err := syscall.Mount("tmpfs", target, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, data); err != nil {
+        return fmt.Errorf("mount tmpfs on %s: %w", target, err)
+    }
+    return nil
+}
+
+// setExecAttr sets the security attributes the LSM applies to the next program executed by the calling thread.
+func setExecAttr(lsm, value string) error {
+    name := filepath.Join(fmt.Sprintf("/proc/self/task/%d/attr", syscall.Gettid()), lsm, "exec")
+    f, err := os.OpenFile(name, os.O_WRONLY, 0)
+    if err != nil {
+        return err
+    }
+    _, err = f.WriteString(value)
+    return errors.Join(err, f.Close())
+}
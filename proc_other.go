@@ -0,0 +1,27 @@
+//go:build !unix && !windows
+
+package subflow
+
+import "os/exec"
+
+// prepare applies the options to c before it is started.
+func (o *options) prepare(*exec.Cmd, *resources) error {
+    if o.cmdLine != nil {
+        return unsupported("command line")
+    } else if name := o.linuxOnly(); name != "" {
+        return unsupported(name)
+    } else if len(o.cpuAffinity) > 0 {
+        return unsupported("CPU affinity")
+    }
+    return nil
+}
+
+// start starts c.
+func (o *options) start(c *exec.Cmd) error {
+    if o.umask != nil {
+        return unsupported("umask")
+    } else if o.nice != nil {
+        return unsupported("nice value")
+    }
+    return c.Start()
+}
@@ -0,0 +1,57 @@
+//go:build unix && !linux
+
+package subflow
+
+import (
+    "errors"
+    "fmt"
+    "os/exec"
+    "sync"
+    "syscall"
+)
+
+// umaskLock serializes umask changes, the umask is shared by the whole process.
+var umaskLock sync.Mutex
+
+// prepare applies the options to c before it is started.
+func (o *options) prepare(*exec.Cmd, *resources) error {
+    if o.cmdLine != nil {
+        return unsupported("command line")
+    } else if name := o.linuxOnly(); name != "" {
+        return unsupported(name)
+    } else if len(o.cpuAffinity) > 0 {
+        return unsupported("CPU affinity")
+    }
+    return nil
+}
+
+// start starts c and sets its nice value.
+func (o *options) start(c *exec.Cmd) error {
+    if err := o.startUmask(c); err != nil {
+        return err
+    } else if o.nice == nil {
+        return nil
+    } else if err := syscall.Setpriority(syscall.PRIO_PROCESS, c.Process.Pid, *o.nice); err != nil {
+        // Do not leave a process behind that the caller will never wait for.
+        _ = c.Process.Kill()
+        return errors.Join(fmt.Errorf("set nice value: %w", err), c.Wait())
+    }
+    return nil
+}
+
+// startUmask starts c.
+// The umask is swapped only for as long as it takes to fork the child,
+// files created by other goroutines in the meantime will also use it.
+func (o *options) startUmask(c *exec.Cmd) error {
+    if o.umask == nil {
+        return c.Start()
+    }
+
+    umaskLock.Lock()
+    defer umaskLock.Unlock()
+    defer syscall.Umask(syscall.Umask(int(o.umask.Perm())))
+    return c.Start()
+}
+
+// exitedUnreaped reports if the process pid has exited but was not reaped yet, which is only known on Linux.
+func exitedUnreaped(int) (code int, exited bool) { return 0, false }
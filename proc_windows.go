@@ -0,0 +1,100 @@
+//go:build windows
+
+package subflow
+
+import (
+    "errors"
+    "fmt"
+    "math/bits"
+    "os/exec"
+    "syscall"
+)
+
+var (
+    kernel32                   = syscall.NewLazyDLL("kernel32.dll")
+    procGetConsoleOutputCP     = kernel32.NewProc("GetConsoleOutputCP")
+    procGetOEMCP               = kernel32.NewProc("GetOEMCP")
+    procIsDBCSLeadByteEx       = kernel32.NewProc("IsDBCSLeadByteEx")
+    procMultiByteToWideChar    = kernel32.NewProc("MultiByteToWideChar")
+    procSetProcessAffinityMask = kernel32.NewProc("SetProcessAffinityMask")
+)
+
+const processSetInformation = 0x0200
+
+// prepare applies the options to c before it is started.
+func (o *options) prepare(c *exec.Cmd, _ *resources) error {
+    if name := o.linuxOnly(); name != "" {
+        return unsupported(name)
+    }
+    if o.cmdLine != nil {
+        sysProcAttr(c).CmdLine = *o.cmdLine
+    }
+    if o.nice != nil {
+        sysProcAttr(c).CreationFlags |= priorityClass(*o.nice)
+    }
+    return nil
+}
+
+// Process priority classes of CreateProcess.
+const (
+    idlePriorityClass        = 0x00000040
+    belowNormalPriorityClass = 0x00004000
+    normalPriorityClass      = 0x00000020
+    aboveNormalPriorityClass = 0x00008000
+    highPriorityClass        = 0x00000080
+)
+
+// priorityClass returns the priority class nearest to the nice value n.
+func priorityClass(n int) uint32 {
+    switch {
+    case n >= 15:
+        return idlePriorityClass
+    case n > 0:
+        return belowNormalPriorityClass
+    case n == 0:
+        return normalPriorityClass
+    case n > -15:
+        return aboveNormalPriorityClass
+    default:
+        return highPriorityClass
+    }
+}
+
+// start starts c.
+func (o *options) start(c *exec.Cmd) error {
+    if o.umask != nil {
+        return unsupported("umask")
+    } else if err := c.Start(); err != nil {
+        return err
+    }
+
+    if len(o.cpuAffinity) > 0 {
+        if err := setAffinity(c.Process.Pid, o.cpuAffinity); err != nil {
+            // Do not leave a process behind that the caller will never wait for.
+            _ = c.Process.Kill()
+            return errors.Join(err, c.Wait())
+        }
+    }
+    return nil
+}
+
+// setAffinity pins the process pid to cpus.
+func setAffinity(pid int, cpus []int) error {
+    var mask uintptr
+    for _, cpu := range cpus {
+        if cpu < 0 || cpu >= bits.UintSize {
+            return fmt.Errorf("invalid cpu %d", cpu)
+        }
+        mask |= 1 << cpu
+    }
+
+    h, err := syscall.OpenProcess(processSetInformation, false, uint32(pid))
+    if err != nil {
+        return fmt.Errorf("open process: %w", err)
+    }
+    defer syscall.CloseHandle(h)
+    if r, _, err := procSetProcessAffinityMask.Call(uintptr(h), mask); r == 0 {
+        return fmt.Errorf("set cpu affinity: %w", err)
+    }
+    return nil
+}
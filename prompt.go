@@ -0,0 +1,60 @@
+package subflow
+
+import (
+    "bytes"
+    "regexp"
+)
+
+// PasswordPrompt matches common password and passphrase prompts, e.g. "[sudo] password for user: " or "Enter passphrase: ".
+var PasswordPrompt = regexp.MustCompile(`(?i)(password|passphrase|passcode)[^\n:]*:\s*$`)
+
+// Prompt answers a prompt of a command with a secret, see WithPrompts.
+type Prompt struct {
+    // Pattern matches the incomplete last line of stdout or stderr, where a prompt waits for its answer.
+    Pattern *regexp.Regexp
+    // Secret returns the answer to the prompt, it is written to stdin followed by a newline and zeroed afterwards.
+    // If it fails the prompt is not answered and the error is reported with an ErrorMessage.
+    Secret func(prompt string) ([]byte, error)
+}
+
+// promptScanner returns a function matching the incomplete last line of an output stream against the prompts of WithPrompts,
+// or nil if there are none. The function must not be called concurrently.
+func (cmd *Cmd) promptScanner() func([]byte) {
+    prompts := cmd.opts.prompts
+    if len(prompts) == 0 {
+        return nil
+    }
+    var line []byte
+    return func(b []byte) {
+        if i := bytes.LastIndexByte(b, '\n'); i >= 0 {
+            line, b = line[:0], b[i+1:]
+        }
+        line = append(line, b...)
+        if len(line) > maxFatalLine {
+            line = append(line[:0], line[len(line)-maxFatalLine:]...)
+        }
+        for _, p := range prompts {
+            if p.Pattern.Match(line) {
+                // The secret may be asked for interactively, so the output keeps flowing meanwhile.
+                go cmd.answer(p, string(line))
+                line = line[:0]
+                return
+            }
+        }
+    }
+}
+
+// answer pushes the secret for prompt to stdin.
+func (cmd *Cmd) answer(p Prompt, prompt string) {
+    secret, err := p.Secret(prompt)
+    if err != nil {
+        cmd.out.Push(NewErrorMessage("prompt", err))
+        return
+    }
+    answer := append(append(make([]byte, 0, len(secret)+1), secret...), '\n')
+    clear(secret)
+    if err := cmd.PushErr(NewSecretInput(answer)); err != nil {
+        clear(answer)
+        cmd.out.Push(NewErrorMessage("prompt", err))
+    }
+}
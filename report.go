@@ -0,0 +1,209 @@
+package subflow
+
+import (
+    "fmt"
+    "html/template"
+    "io"
+    "strings"
+    "time"
+)
+
+var _ Sink = (*HTMLReport)(nil)
+
+// HTMLReport is a Sink collecting the messages of a command, which writes a standalone HTML report of the run to w once it is closed.
+type HTMLReport struct {
+    w    io.Writer
+    msgs []Message
+}
+
+// NewHTMLReport returns an HTMLReport writing to w.
+func NewHTMLReport(w io.Writer) *HTMLReport {
+    return &HTMLReport{w: w}
+}
+
+func (r *HTMLReport) Write(msg Message) error {
+    r.msgs = append(r.msgs, msg)
+    return nil
+}
+
+// Close writes the report, it does not close the underlying writer.
+func (r *HTMLReport) Close() error {
+    return WriteHTMLReport(r.w, r.msgs)
+}
+
+// WriteHTMLReport writes a standalone HTML report of the run made of msgs to w,
+// with its exit status, a timeline of its messages and its collapsible stdout, stderr and stdin.
+// If msgs contain UsageMessages, e.g. with WithUsage, a graph of the CPU and memory usage is included.
+func WriteHTMLReport(w io.Writer, msgs []Message) error {
+    return reportTemplate.Execute(w, newReport(msgs))
+}
+
+type report struct {
+    Start    time.Time
+    Duration time.Duration
+    Exit     *ExitMessage
+    Timeline []reportEvent
+    Streams  []reportStream
+    Usage    *reportGraph
+}
+
+type reportEvent struct {
+    Offset  time.Duration
+    Kind    string
+    Content string
+}
+
+type reportStream struct {
+    Name string
+    Data []byte
+}
+
+// Size of the resource graph in pixels.
+const (
+    graphWidth  = 800
+    graphHeight = 200
+)
+
+// reportGraph is the resource graph of a report, the points are SVG polyline coordinates scaled to the graph size.
+type reportGraph struct {
+    Width, Height int
+    CPU, RSS      string
+    MaxCPU        float64
+    MaxRSS        float64 // MiB
+}
+
+// newReportGraph returns the graph of the usage samples, taken at the offsets of a run lasting duration.
+func newReportGraph(samples []UsageMessage, offsets []time.Duration, duration time.Duration) *reportGraph {
+    if len(samples) == 0 {
+        return nil
+    }
+    g := &reportGraph{Width: graphWidth, Height: graphHeight}
+    for _, u := range samples {
+        g.MaxCPU = max(g.MaxCPU, u.CPU)
+        g.MaxRSS = max(g.MaxRSS, float64(u.RSS)/(1<<20))
+    }
+    var cpu, rss strings.Builder
+    for i, u := range samples {
+        x := float64(graphWidth)
+        if duration > 0 {
+            x = float64(offsets[i]) / float64(duration) * graphWidth
+        }
+        fmt.Fprintf(&cpu, "%.1f,%.1f ", x, graphY(u.CPU, g.MaxCPU))
+        fmt.Fprintf(&rss, "%.1f,%.1f ", x, graphY(float64(u.RSS)/(1<<20), g.MaxRSS))
+    }
+    g.CPU, g.RSS = cpu.String(), rss.String()
+    return g
+}
+
+// graphY returns the y coordinate of v on a graph going up to limit.
+func graphY(v, limit float64) float64 {
+    if limit <= 0 {
+        return graphHeight
+    }
+    return graphHeight - v/limit*graphHeight
+}
+
+func newReport(msgs []Message) report {
+    var r report
+    var stdin, stdout, stderr []byte
+    var usage []UsageMessage
+    var usageOffsets []time.Duration
+    for _, msg := range msgs {
+        t := messageTime(msg)
+        if r.Start.IsZero() {
+            r.Start = t
+        }
+        r.Duration = t.Sub(r.Start)
+
+        switch msg := msg.(type) {
+        case StdinMessage:
+            stdin = append(stdin, msg.Data...)
+            continue
+        case StdoutMessage:
+            stdout = append(stdout, msg.Data...)
+            continue
+        case StderrMessage:
+            stderr = append(stderr, msg.Data...)
+            continue
+        case UsageMessage:
+            usage = append(usage, msg)
+            usageOffsets = append(usageOffsets, t.Sub(r.Start))
+            continue
+        case ExitMessage:
+            r.Exit = &msg
+        }
+        kind, content := transcriptLine(msg)
+        r.Timeline = append(r.Timeline, reportEvent{
+            Offset:  t.Sub(r.Start),
+            Kind:    kind,
+            Content: content,
+        })
+    }
+    r.Streams = []reportStream{{"stdout", stdout}, {"stderr", stderr}, {"stdin", stdin}}
+    r.Usage = newReportGraph(usage, usageOffsets, r.Duration)
+    return r
+}
+
+// messageTime returns the time of msg, or the zero time if it has none.
+func messageTime(msg Message) time.Time {
+    if msg, ok := msg.(interface{ messageTime() time.Time }); ok {
+        return msg.messageTime()
+    }
+    return time.Time{}
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>subflow report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+td, th { padding: .2em .8em; text-align: left; border-bottom: 1px solid #ddd; }
+pre { background: #f6f6f6; padding: 1em; overflow-x: auto; }
+.ok { color: #187a2f; }
+.fail { color: #b3261e; }
+.cpu { color: #1f6feb; stroke: #1f6feb; }
+.rss { color: #8250df; stroke: #8250df; }
+svg { border: 1px solid #ddd; }
+polyline { fill: none; stroke-width: 2; }
+</style>
+</head>
+<body>
+<h1>subflow report</h1>
+<table>
+<tr><th>Started</th><td>{{.Start.Format "2006-01-02 15:04:05.000 MST"}}</td></tr>
+<tr><th>Duration</th><td>{{.Duration}}</td></tr>
+{{with .Exit}}
+<tr><th>Exit code</th><td class="{{if eq .Code 0}}ok{{else}}fail{{end}}">{{.Code}}</td></tr>
+<tr><th>Reason</th><td>{{.Reason}} ({{.Actor}})</td></tr>
+<tr><th>First output</th><td>{{.Stats.FirstOutput}}</td></tr>
+<tr><th>Longest silence</th><td>{{.Stats.LongestSilence}}</td></tr>
+{{else}}
+<tr><th>Exit code</th><td>running</td></tr>
+{{end}}
+</table>
+{{with .Usage}}
+<h2>Resources</h2>
+<svg width="{{.Width}}" height="{{.Height}}" viewBox="0 0 {{.Width}} {{.Height}}">
+<polyline class="cpu" points="{{.CPU}}"/>
+<polyline class="rss" points="{{.RSS}}"/>
+</svg>
+<p><span class="cpu">CPU</span> up to {{printf "%.1f" .MaxCPU}}%, <span class="rss">memory</span> up to {{printf "%.1f" .MaxRSS}} MiB</p>
+{{end}}
+<h2>Timeline</h2>
+<table>
+<tr><th>Offset</th><th>Kind</th><th>Details</th></tr>
+{{range .Timeline}}<tr><td>+{{.Offset}}</td><td>{{.Kind}}</td><td>{{.Content}}</td></tr>
+{{end}}
+</table>
+<h2>Output</h2>
+{{range .Streams}}<details{{if eq .Name "stdout"}} open{{end}}>
+<summary>{{.Name}} ({{len .Data}} bytes)</summary>
+<pre>{{printf "%s" .Data}}</pre>
+</details>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,57 @@
+package subflow
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "strconv"
+)
+
+// ErrResumeExpired is returned by Cmd.ListenResume when the messages after a token are no longer kept.
+var ErrResumeExpired = errors.New("resume token expired")
+
+// ResumableMessage is a message received from Cmd.ListenResume with the token to resume after it.
+type ResumableMessage struct {
+    Message Message
+    Token   string
+}
+
+func (s *outStream) listenResume(ctx context.Context, token string) (<-chan ResumableMessage, error) {
+    var after int64 = -1
+    if token != "" {
+        var err error
+        if after, err = strconv.ParseInt(token, 10, 64); err != nil {
+            return nil, fmt.Errorf("invalid resume token %q", token)
+        }
+    }
+
+    history, seqs, next, c, err := s.resume(ctx, after)
+    if err != nil {
+        return nil, err
+    }
+
+    out := make(chan ResumableMessage)
+    go func() {
+        defer close(out)
+        send := func(msg Message, seq int64) bool {
+            select {
+            case <-ctx.Done():
+                return false
+            case out <- ResumableMessage{Message: msg, Token: strconv.FormatInt(seq, 10)}:
+                return true
+            }
+        }
+        for i, msg := range history {
+            if !send(msg, seqs[i]) {
+                return
+            }
+        }
+        for msg := range c {
+            next++
+            if !send(msg, next) {
+                return
+            }
+        }
+    }()
+    return out, nil
+}
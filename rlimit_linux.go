@@ -0,0 +1,45 @@
+//go:build linux
+
+package subflow
+
+import (
+    "fmt"
+    "os"
+    "syscall"
+    "time"
+    "unsafe"
+)
+
+// setRlimits sets the resource limits of the process pid.
+func setRlimits(pid int, limits []rlimit) error {
+    for _, l := range limits {
+        lim := syscall.Rlimit{Cur: l.soft, Max: l.hard}
+        if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(l.resource), uintptr(unsafe.Pointer(&lim)), 0, 0, 0); errno != 0 {
+            return fmt.Errorf("set resource limit %d: %w", l.resource, errno)
+        }
+    }
+    return nil
+}
+
+// limitExceeded returns the resource limit the process of state was killed by exceeding, and the signal it was killed with.
+// The soft CPU and file size limits send SIGXCPU and SIGXFSZ, the hard CPU limit SIGKILL.
+func (o *options) limitExceeded(state *os.ProcessState) (string, os.Signal, bool) {
+    sig, ok := terminatingSignal(state)
+    if !ok {
+        return "", nil, false
+    }
+    for _, l := range o.rlimits {
+        switch {
+        case l.resource == syscall.RLIMIT_CPU && sig == syscall.SIGXCPU:
+            return "cpu", sig, true
+        case l.resource == syscall.RLIMIT_FSIZE && sig == syscall.SIGXFSZ:
+            return "fsize", sig, true
+        case l.resource == syscall.RLIMIT_CPU && sig == syscall.SIGKILL:
+            // The limit is in seconds, the time the process was charged may be a little short of it.
+            if used := state.UserTime() + state.SystemTime(); l.hard <= uint64(used.Round(time.Second)/time.Second) {
+                return "cpu", sig, true
+            }
+        }
+    }
+    return "", nil, false
+}
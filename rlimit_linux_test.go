@@ -0,0 +1,19 @@
+package subflow
+
+import (
+    "context"
+    "strings"
+    "syscall"
+    "testing"
+)
+
+func TestRlimitSetBeforeExec(t *testing.T) {
+    // The shell reads its limits right away, before a limit set after the exec could apply.
+    out := Run(context.Background(), NewCommandArgs("sh", []string{"-c", "ulimit -n; ulimit -Hn"}), nil, WithRlimit(syscall.RLIMIT_NOFILE, 17, 33))
+    if err := out.Err(); err != nil {
+        t.Fatal(err)
+    }
+    if got := strings.Fields(string(out.Stdout())); len(got) != 2 || got[0] != "17" || got[1] != "33" {
+        t.Errorf("limits = %q, want [17 33]", got)
+    }
+}
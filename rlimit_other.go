@@ -0,0 +1,8 @@
+//go:build !linux
+
+package subflow
+
+import "os"
+
+// limitExceeded returns the resource limit the process of state was killed by exceeding, resource limits are only supported on Linux.
+func (o *options) limitExceeded(*os.ProcessState) (string, os.Signal, bool) { return "", nil, false }
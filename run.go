@@ -5,6 +5,7 @@ import (
     "context"
     "errors"
     "fmt"
+    "io"
     "os/exec"
 )
 
@@ -17,19 +18,19 @@ type Output struct {
 }
 
 // Run executes a command with the provided context and optional standard input.
-func Run(ctx context.Context, cmd Command, stdin []byte) (out Output) {
-    command, args, env := commandCollect(cmd)
+func Run(ctx context.Context, cmd Command, stdin []byte, opts ...Option) (out Output) {
+    spec := resolveCommand(cmd)
+    o := newOptions(spec.options(opts))
     // Prepare the command with its context, command name, and arguments.
-    c := exec.CommandContext(ctx, command, args...)
-    // Set the environment variables for the command.
-    c.Env = env
+    c := exec.CommandContext(ctx, spec.command, spec.args...)
     // Buffers to capture standard output and standard error streams.
     var stdout, stderr bytes.Buffer
-    c.Stdout, c.Stderr = &stdout, &stderr
-    // Set standard input for the command
-    c.Stdin = bytes.NewReader(stdin)
-    // Execute the command and capture any errors.
-    out.err = c.Run()
+    // Set the environment variables, working directory and user for the command, then execute it.
+    if err := spec.configure(c); err != nil {
+        out.err = err
+    } else {
+        out.err = o.runCaptured(c, stdin, &stdout, &stderr)
+    }
     // Populate the Output struct with the results of execution.
     out.stdout = stdout.Bytes()
     out.stderr = stderr.Bytes()
@@ -44,6 +45,30 @@ func Run(ctx context.Context, cmd Command, stdin []byte) (out Output) {
     return out
 }
 
+// runCaptured runs c with stdin, capturing its output to stdout and stderr.
+func (o *options) runCaptured(c *exec.Cmd, stdin []byte, stdout, stderr io.Writer) error {
+    c.Stdout, c.Stderr = o.outputWriter(stdout), o.outputWriter(stderr)
+    // Set standard input for the command, mirroring it to the tee if there is one.
+    c.Stdin = io.MultiReader(append([]io.Reader{bytes.NewReader(stdin)}, o.stdinReaders...)...)
+    tee := o.tee()
+    if tee != nil {
+        c.Stdin = io.TeeReader(c.Stdin, tee)
+    }
+    // Execute the command and capture any errors.
+    in := c.Stdin
+    res, err := o.apply(c)
+    if err != nil {
+        return err
+    }
+    if res.stdin != nil {
+        go func() {
+            _, _ = io.Copy(res.stdin, in)
+            _ = res.stdin.Close()
+        }()
+    }
+    return errors.Join(o.run(c), res.drainOutput(), res.release(), tee.Err())
+}
+
 // Stdout returns the standard output captured during command execution.
 func (out *Output) Stdout() []byte {
     return out.stdout
@@ -0,0 +1,45 @@
+package subflow
+
+import (
+    "context"
+    "runtime"
+    "strings"
+    "testing"
+)
+
+func TestRunEnvExtendsEnvironment(t *testing.T) {
+    if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+        t.Skip("needs sh")
+    }
+    t.Setenv("SUBFLOW_TEST_INHERITED", "inherited")
+    t.Setenv("SUBFLOW_TEST_SET", "replaced")
+    script := []string{"-c", `echo "$SUBFLOW_TEST_INHERITED $SUBFLOW_TEST_SET"`}
+
+    out := Run(context.Background(), NewCommandArgsEnv("sh", script, []string{"SUBFLOW_TEST_SET=set"}), nil)
+    if err := out.Err(); err != nil {
+        t.Fatal(err)
+    }
+    if got := strings.TrimSpace(string(out.Stdout())); got != "inherited set" {
+        t.Errorf("stdout = %q, want %q", got, "inherited set")
+    }
+
+    exact := WithEnvPolicy(NewCommandArgsEnv("/bin/sh", script, []string{"SUBFLOW_TEST_SET=set"}), EnvPolicy{Deny: []string{"*"}})
+    out = Run(context.Background(), exact, nil)
+    if err := out.Err(); err != nil {
+        t.Fatal(err)
+    }
+    if got := strings.TrimSpace(string(out.Stdout())); got != "set" {
+        t.Errorf("stdout with an exact environment = %q, want %q", got, "set")
+    }
+}
+
+func TestRunConfigureFailure(t *testing.T) {
+    // An invalid pattern fails while the environment of the command is set up, before it is started.
+    out := Run(context.Background(), WithEnvPolicy(NewCommand("true"), EnvPolicy{Allow: []string{"["}}), nil)
+    if out.Code() != -1 {
+        t.Errorf("code = %d, want -1", out.Code())
+    }
+    if err := out.Err(); err == nil || !strings.HasPrefix(err.Error(), `stderr("")`) {
+        t.Errorf("error = %v, want it wrapped with the stderr", err)
+    }
+}
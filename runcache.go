@@ -0,0 +1,196 @@
+package subflow
+
+import (
+    "container/list"
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "slices"
+    "sync"
+    "time"
+)
+
+// RunCache caches the results of successful runs of commands whose output only depends on their arguments,
+// keyed by the command, its arguments, environment, directory, user and stdin.
+// Variables inherited from the current process are not part of the key.
+// Failed runs and commands reading their stdin from an io.Reader are never cached.
+type RunCache struct {
+    ttl      time.Duration
+    maxBytes int64
+    dir      string
+
+    lock    sync.Mutex
+    entries map[[sha256.Size]byte]*list.Element
+    lru     list.List
+    size    int64
+}
+
+// cacheEntry is a cached result, it is stored on disk as JSON.
+type cacheEntry struct {
+    key    [sha256.Size]byte
+    Time   time.Time `json:"time"`
+    Stdout []byte    `json:"stdout"`
+    Stderr []byte    `json:"stderr"`
+}
+
+func (e *cacheEntry) size() int64 { return int64(len(e.Stdout) + len(e.Stderr)) }
+
+// NewRunCache returns a RunCache keeping results in memory for ttl, evicting the least recently used ones beyond maxBytes of output.
+// A ttl or maxBytes of 0 does not limit them.
+func NewRunCache(ttl time.Duration, maxBytes int64) *RunCache {
+    return &RunCache{
+        ttl:      ttl,
+        maxBytes: maxBytes,
+        entries:  make(map[[sha256.Size]byte]*list.Element),
+    }
+}
+
+// NewDiskRunCache returns a RunCache keeping results as files in dir for ttl, so they are shared between processes.
+// The oldest files are removed once the files exceed maxBytes. A ttl or maxBytes of 0 does not limit them.
+func NewDiskRunCache(dir string, ttl time.Duration, maxBytes int64) (*RunCache, error) {
+    if err := os.MkdirAll(dir, 0o700); err != nil {
+        return nil, err
+    }
+    return &RunCache{ttl: ttl, maxBytes: maxBytes, dir: dir}, nil
+}
+
+// Run returns the cached result of running cmd with stdin, or runs it like Run and caches its result if it succeeds.
+// cached reports if the result was cached. Options are not part of the key.
+func (c *RunCache) Run(ctx context.Context, cmd Command, stdin []byte, opts ...Option) (out Output, cached bool) {
+    spec := resolveCommand(cmd)
+    if spec.stdin != nil {
+        return Run(ctx, cmd, stdin, opts...), false
+    }
+    key := spec.key(stdin)
+    if e := c.get(key); e != nil {
+        return Output{stdout: e.Stdout, stderr: e.Stderr}, true
+    }
+
+    out = Run(ctx, cmd, stdin, opts...)
+    if out.err == nil {
+        // A cache failing to store a result does not fail the run.
+        _ = c.put(&cacheEntry{key: key, Time: time.Now(), Stdout: out.stdout, Stderr: out.stderr})
+    }
+    return out, false
+}
+
+func (c *RunCache) expired(e *cacheEntry) bool {
+    return c.ttl > 0 && time.Since(e.Time) >= c.ttl
+}
+
+func (c *RunCache) get(key [sha256.Size]byte) *cacheEntry {
+    if c.dir != "" {
+        return c.getFile(key)
+    }
+    c.lock.Lock()
+    defer c.lock.Unlock()
+    el, ok := c.entries[key]
+    if !ok {
+        return nil
+    } else if e := el.Value.(*cacheEntry); c.expired(e) {
+        c.remove(el)
+        return nil
+    } else {
+        c.lru.MoveToFront(el)
+        return e
+    }
+}
+
+func (c *RunCache) put(e *cacheEntry) error {
+    if c.dir != "" {
+        return c.putFile(e)
+    }
+    c.lock.Lock()
+    defer c.lock.Unlock()
+    if el, ok := c.entries[e.key]; ok {
+        c.remove(el)
+    }
+    c.entries[e.key] = c.lru.PushFront(e)
+    c.size += e.size()
+    for c.maxBytes > 0 && c.size > c.maxBytes {
+        c.remove(c.lru.Back())
+    }
+    return nil
+}
+
+func (c *RunCache) remove(el *list.Element) {
+    e := c.lru.Remove(el).(*cacheEntry)
+    delete(c.entries, e.key)
+    c.size -= e.size()
+}
+
+func (c *RunCache) path(key [sha256.Size]byte) string {
+    return filepath.Join(c.dir, hex.EncodeToString(key[:]))
+}
+
+func (c *RunCache) getFile(key [sha256.Size]byte) *cacheEntry {
+    b, err := os.ReadFile(c.path(key))
+    if err != nil {
+        return nil
+    }
+    e := &cacheEntry{key: key}
+    if err := json.Unmarshal(b, e); err != nil || c.expired(e) {
+        _ = os.Remove(c.path(key))
+        return nil
+    }
+    return e
+}
+
+func (c *RunCache) putFile(e *cacheEntry) error {
+    b, err := json.Marshal(e)
+    if err != nil {
+        return err
+    }
+    // Write to a temporary file first, so readers never see a partial result.
+    f, err := os.CreateTemp(c.dir, ".tmp-")
+    if err != nil {
+        return err
+    }
+    _, err = f.Write(b)
+    if err = errors.Join(err, f.Close()); err == nil {
+        err = os.Rename(f.Name(), c.path(e.key))
+    }
+    if err != nil {
+        return errors.Join(err, os.Remove(f.Name()))
+    }
+    return c.trimFiles()
+}
+
+// trimFiles removes the expired files and the oldest ones beyond maxBytes.
+func (c *RunCache) trimFiles() error {
+    entries, err := os.ReadDir(c.dir)
+    if err != nil {
+        return err
+    }
+    var files []fs.FileInfo
+    var size int64
+    for _, entry := range entries {
+        info, err := entry.Info()
+        if err != nil || !info.Mode().IsRegular() || len(info.Name()) != hex.EncodedLen(sha256.Size) {
+            continue
+        }
+        if c.ttl > 0 && time.Since(info.ModTime()) >= c.ttl {
+            _ = os.Remove(filepath.Join(c.dir, info.Name()))
+            continue
+        }
+        files = append(files, info)
+        size += info.Size()
+    }
+    if c.maxBytes <= 0 {
+        return nil
+    }
+    slices.SortFunc(files, func(a, b fs.FileInfo) int { return a.ModTime().Compare(b.ModTime()) })
+    for _, info := range files {
+        if size <= c.maxBytes {
+            break
+        }
+        _ = os.Remove(filepath.Join(c.dir, info.Name()))
+        size -= info.Size()
+    }
+    return nil
+}
@@ -0,0 +1,112 @@
+package subflow
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/binary"
+    "hash"
+    "sync"
+)
+
+// RunGroup deduplicates runs of identical commands.
+// Concurrent calls of Run with the same command, arguments, environment, directory, user and stdin share a single execution,
+// every caller receives the same Output. Runs started after it completed execute the command again.
+// The zero value is ready to use.
+type RunGroup struct {
+    lock  sync.Mutex
+    calls map[[sha256.Size]byte]*runCall
+}
+
+type runCall struct {
+    done    chan struct{}
+    out     Output
+    waiters int
+    cancel  context.CancelFunc
+}
+
+// Run runs cmd like Run, or waits for the identical run already in progress.
+// The options of the caller starting the execution are used, the options of callers joining it are ignored.
+// The execution is stopped once the contexts of all callers waiting for it are done.
+// The returned Output is shared with the other callers, its data must not be modified.
+// shared reports if the execution was started by another caller.
+// Commands reading their stdin from an io.Reader are never shared.
+func (g *RunGroup) Run(ctx context.Context, cmd Command, stdin []byte, opts ...Option) (out Output, shared bool) {
+    spec := resolveCommand(cmd)
+    if spec.stdin != nil {
+        return Run(ctx, cmd, stdin, opts...), false
+    }
+    key := spec.key(stdin)
+
+    g.lock.Lock()
+    call, shared := g.calls[key]
+    if !shared {
+        // The execution outlives the context of the caller starting it, as long as another caller waits for it.
+        runCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+        call = &runCall{done: make(chan struct{}), cancel: cancel}
+        if g.calls == nil {
+            g.calls = make(map[[sha256.Size]byte]*runCall)
+        }
+        g.calls[key] = call
+        go func() {
+            defer cancel()
+            call.out = Run(runCtx, cmd, stdin, opts...)
+            g.forget(key, call)
+            close(call.done)
+        }()
+    }
+    call.waiters++
+    g.lock.Unlock()
+
+    select {
+    case <-call.done:
+        return call.out, shared
+    case <-ctx.Done():
+        g.lock.Lock()
+        if call.waiters--; call.waiters == 0 {
+            call.cancel()
+            g.forgetLocked(key, call)
+        }
+        g.lock.Unlock()
+        return Output{code: -1, err: ctx.Err()}, shared
+    }
+}
+
+// forget removes call, so later runs of key execute the command again.
+func (g *RunGroup) forget(key [sha256.Size]byte, call *runCall) {
+    g.lock.Lock()
+    defer g.lock.Unlock()
+    g.forgetLocked(key, call)
+}
+
+func (g *RunGroup) forgetLocked(key [sha256.Size]byte, call *runCall) {
+    if g.calls[key] == call {
+        delete(g.calls, key)
+    }
+}
+
+// key returns a hash identifying a run of spec with stdin.
+func (spec commandSpec) key(stdin []byte) (key [sha256.Size]byte) {
+    h := sha256.New()
+    writeKey(h, spec.command, spec.dir, spec.user)
+    writeKey(h, spec.args...)
+    writeKey(h, spec.env...)
+    if spec.policy != nil {
+        writeKey(h, "policy")
+        writeKey(h, spec.policy.Allow...)
+        writeKey(h, spec.policy.Deny...)
+    } else {
+        writeKey(h, "")
+    }
+    writeKey(h, string(stdin))
+    h.Sum(key[:0])
+    return key
+}
+
+// writeKey writes the length prefixed list of strings to h, so different lists never write the same bytes.
+func writeKey(h hash.Hash, s ...string) {
+    _ = binary.Write(h, binary.LittleEndian, uint64(len(s)))
+    for _, s := range s {
+        _ = binary.Write(h, binary.LittleEndian, uint64(len(s)))
+        _, _ = h.Write([]byte(s))
+    }
+}
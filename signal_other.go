@@ -0,0 +1,8 @@
+//go:build !unix
+
+package subflow
+
+import "os"
+
+// terminatingSignal returns the signal which terminated the process of state, processes are not terminated by signals.
+func terminatingSignal(*os.ProcessState) (os.Signal, bool) { return nil, false }
@@ -0,0 +1,16 @@
+//go:build unix
+
+package subflow
+
+import (
+    "os"
+    "syscall"
+)
+
+// terminatingSignal returns the signal which terminated the process of state, if it was terminated by one.
+func terminatingSignal(state *os.ProcessState) (os.Signal, bool) {
+    if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+        return ws.Signal(), true
+    }
+    return nil, false
+}
@@ -0,0 +1,94 @@
+package subflow
+
+import (
+    "context"
+    "errors"
+    "sync"
+    "time"
+)
+
+// ErrFlushTimeout is returned when the sinks of a command were not flushed and closed within the timeout set by WithFlushTimeout.
+var ErrFlushTimeout = errors.New("sink flush timed out")
+
+// Sink consumes every message of a command in order, from the StartMessage to the final ExitMessage.
+// Sinks are registered with WithSink.
+type Sink interface {
+    // Write receives the next message of the command. Once it returns an error the sink receives no more messages.
+    Write(Message) error
+    // Close is called once after the final message was written and the sink was flushed.
+    Close() error
+}
+
+// Flusher is implemented by a Sink which buffers messages, Flush is called before Close.
+type Flusher interface {
+    Flush() error
+}
+
+// sinkGroup delivers the messages of a command to its sinks.
+type sinkGroup struct {
+    wg   sync.WaitGroup
+    lock sync.Mutex
+    err  error
+}
+
+// start delivers the messages of out to sink in the background.
+// The listener does not use the context of the command so the final messages are delivered after it is closed.
+// A message the sink fails to write, after the retries of WithSinkRetry, is reported with an ErrorMessage.
+func (sg *sinkGroup) start(out *outStream, sink Sink, o *options) {
+    msgs := out.Listen(context.Background())
+    sg.wg.Add(1)
+    go func() {
+        defer sg.wg.Done()
+        err := runSink(sink, msgs, o.sinkRetries, o.sinkBackoff, func(err error) { out.Push(NewErrorMessage("sink", err)) })
+        sg.lock.Lock()
+        defer sg.lock.Unlock()
+        sg.err = errors.Join(sg.err, err)
+    }()
+}
+
+func runSink(sink Sink, msgs <-chan Message, retries int, backoff Backoff, report func(error)) (err error) {
+    for msg := range msgs {
+        if err != nil {
+            continue
+        } else if err = writeSink(sink, msg, retries, backoff); err != nil {
+            report(err)
+        }
+    }
+    if f, ok := sink.(Flusher); ok {
+        err = errors.Join(err, f.Flush())
+    }
+    return errors.Join(err, sink.Close())
+}
+
+// writeSink writes msg to sink, retrying up to retries times if it fails.
+func writeSink(sink Sink, msg Message, retries int, backoff Backoff) error {
+    err := sink.Write(msg)
+    for attempt := 1; err != nil && attempt <= retries; attempt++ {
+        if backoff != nil {
+            time.Sleep(backoff.Delay(attempt))
+        }
+        err = sink.Write(msg)
+    }
+    return err
+}
+
+// wait waits for the sinks to be flushed and closed, a timeout of 0 or less waits indefinitely.
+func (sg *sinkGroup) wait(timeout time.Duration) error {
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        sg.wg.Wait()
+    }()
+
+    if timeout > 0 {
+        select {
+        case <-done:
+        case <-time.After(timeout):
+            return ErrFlushTimeout
+        }
+    }
+    <-done
+    sg.lock.Lock()
+    defer sg.lock.Unlock()
+    return sg.err
+}
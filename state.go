@@ -0,0 +1,77 @@
+package subflow
+
+import (
+    "time"
+)
+
+// RunState is the phase of the lifecycle of a command.
+type RunState int
+
+const (
+    // StateCreated is a command which was not started yet, or whose process is being started.
+    StateCreated RunState = iota
+    // StateRunning is a command with a running process.
+    StateRunning
+    // StateExiting is a command which was closed or whose process has exited, but which did not finish cleaning up, e.g. flushing its sinks.
+    StateExiting
+    // StateExited is a command which is done.
+    StateExited
+)
+
+func (s RunState) String() string {
+    switch s {
+    case StateCreated:
+        return "created"
+    case StateRunning:
+        return "running"
+    case StateExiting:
+        return "exiting"
+    case StateExited:
+        return "exited"
+    }
+    return "unknown"
+}
+
+func (s RunState) MarshalText() ([]byte, error) {
+    return []byte(s.String()), nil
+}
+
+// Status is a snapshot of what a command is doing, see Cmd.State.
+type Status struct {
+    State RunState `json:"state"`
+    // Pid is the process id once the process was started.
+    Pid int `json:"pid,omitempty"`
+    // Started is when the process was started, the zero time before.
+    Started time.Time `json:"started"`
+    // Exit is the final message of the command once its process has exited, nil if it was closed without being started.
+    Exit *ExitMessage `json:"exit,omitempty"`
+}
+
+// State returns what the command is currently doing, e.g. for a health endpoint.
+func (cmd *Cmd) State() Status {
+    status := Status{
+        State:   StateCreated,
+        Started: cmd.stats.startTime(),
+        Exit:    cmd.exit.Load(),
+    }
+    select {
+    case <-cmd.launched:
+        if p := cmd.cmd.Process; p != nil {
+            status.Pid = p.Pid
+        }
+    default:
+        return status
+    }
+
+    select {
+    case <-cmd.Done():
+        status.State = StateExited
+    default:
+        if status.Exit != nil || cmd.ctx.Err() != nil {
+            status.State = StateExiting
+        } else if status.Pid != 0 {
+            status.State = StateRunning
+        }
+    }
+    return status
+}
@@ -0,0 +1,89 @@
+package subflow
+
+import (
+    "sync"
+    "time"
+)
+
+// Stats are the latency metrics of a command.
+type Stats struct {
+    // FirstOutput is the time from the start of the process to its first output on stdout or stderr, 0 if there was none.
+    FirstOutput time.Duration `json:"firstOutput"`
+    // LongestSilence is the longest time the process did not output anything, from its start to its exit.
+    LongestSilence time.Duration `json:"longestSilence"`
+}
+
+// Stats returns the latency metrics of the command, a running command includes its current silence.
+func (cmd *Cmd) Stats() Stats {
+    return cmd.stats.get(time.Now())
+}
+
+// runStats records the latency metrics of a command.
+type runStats struct {
+    lock   sync.Mutex
+    start  time.Time
+    last   time.Time
+    exited bool
+    stats  Stats
+}
+
+func (rs *runStats) started(t time.Time) {
+    rs.lock.Lock()
+    defer rs.lock.Unlock()
+    rs.start, rs.last = t, t
+}
+
+// startTime returns when the process was started, or the zero time if it was not.
+func (rs *runStats) startTime() time.Time {
+    rs.lock.Lock()
+    defer rs.lock.Unlock()
+    return rs.start
+}
+
+// output records an output at t and reports if it was the first one, along with the time since the start.
+func (rs *runStats) output(t time.Time) (first bool, latency time.Duration) {
+    rs.lock.Lock()
+    defer rs.lock.Unlock()
+    if rs.start.IsZero() || rs.exited {
+        return false, 0
+    }
+    first = rs.stats.FirstOutput == 0
+    if first {
+        rs.stats.FirstOutput = max(t.Sub(rs.start), 1)
+    }
+    rs.silence(t)
+    return first, rs.stats.FirstOutput
+}
+
+func (rs *runStats) exit(t time.Time) Stats {
+    rs.lock.Lock()
+    defer rs.lock.Unlock()
+    if !rs.start.IsZero() && !rs.exited {
+        rs.silence(t)
+        rs.exited = true
+    }
+    return rs.stats
+}
+
+func (rs *runStats) get(now time.Time) Stats {
+    rs.lock.Lock()
+    defer rs.lock.Unlock()
+    stats := rs.stats
+    if !rs.start.IsZero() && !rs.exited {
+        stats.LongestSilence = max(stats.LongestSilence, now.Sub(rs.last))
+    }
+    return stats
+}
+
+// silence ends the current silence at t, the stats must be locked.
+func (rs *runStats) silence(t time.Time) {
+    rs.stats.LongestSilence = max(rs.stats.LongestSilence, t.Sub(rs.last))
+    rs.last = t
+}
+
+// lastOutput returns the time of the last output, or of the start if there was none.
+func (rs *runStats) lastOutput() time.Time {
+    rs.lock.Lock()
+    defer rs.lock.Unlock()
+    return rs.last
+}
@@ -0,0 +1,78 @@
+package subflow
+
+import (
+    "context"
+    "errors"
+    "io"
+    "log/slog"
+    "os"
+    "sync"
+)
+
+var _ io.ReadWriteCloser = (*Cmd)(nil)
+
+// Read reads the stdout of the command.
+// Like Listen, output emitted before the first call to Read is lost unless the command has WithBufferedOutput,
+// call it before Start, e.g. from another goroutine, to read all of the output.
+// Read returns io.EOF once the command has exited and its output was read.
+func (cmd *Cmd) Read(b []byte) (int, error) {
+    cmd.readerOnce.Do(func() { cmd.reader.out = cmd.Listen(context.Background()) })
+    return cmd.reader.read(b, nil)
+}
+
+// Write pushes b to the stdin of the command, see PushErr.
+func (cmd *Cmd) Write(b []byte) (int, error) {
+    if err := cmd.PushErr(NewInput(b)); err != nil {
+        return 0, err
+    }
+    return len(b), nil
+}
+
+// pushFrom pushes everything read from r to the stdin of the command, until r is exhausted or the command is closed.
+func (cmd *Cmd) pushFrom(r io.Reader) {
+    b := make([]byte, 32*1024)
+    for {
+        n, err := r.Read(b)
+        if n > 0 {
+            // Rejected inputs are skipped, the rest of the reader may still be accepted.
+            if err := cmd.PushErr(NewInput(b[:n])); err != nil && !errors.Is(err, ErrInputRejected) {
+                return
+            }
+        }
+        if errors.Is(err, io.EOF) {
+            return
+        } else if err != nil {
+            slog.Error("read stdin", "error", err)
+            return
+        }
+    }
+}
+
+// stdoutReader reads the stdout of a command from its messages.
+type stdoutReader struct {
+    lock sync.Mutex
+    out  <-chan Message
+    buf  []byte
+}
+
+// read reads stdout into b, returning os.ErrDeadlineExceeded if timeout closes while waiting for output.
+func (r *stdoutReader) read(b []byte, timeout <-chan struct{}) (int, error) {
+    r.lock.Lock()
+    defer r.lock.Unlock()
+
+    for len(r.buf) == 0 {
+        select {
+        case <-timeout:
+            return 0, os.ErrDeadlineExceeded
+        case msg, ok := <-r.out:
+            if !ok {
+                return 0, io.EOF
+            } else if msg, ok := msg.(StdoutMessage); ok {
+                r.buf = msg.Data
+            }
+        }
+    }
+    n := copy(b, r.buf)
+    r.buf = r.buf[n:]
+    return n, nil
+}
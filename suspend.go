@@ -0,0 +1,37 @@
+package subflow
+
+import (
+    "errors"
+    "os"
+)
+
+// Suspend stops the process of the command until Resume is called, with SIGSTOP on Unix and by suspending its threads on Windows.
+// With WithProcessGroup the whole process group is stopped, other descendants keep running; Freeze stops the whole tree.
+// It returns ErrNotStarted if the command was not started and ErrClosed if it was closed or has exited.
+func (cmd *Cmd) Suspend() error {
+    if err := cmd.setSuspended(true); err != nil {
+        return err
+    }
+    cmd.out.Push(NewSuspendedMessage())
+    return nil
+}
+
+// Resume continues a process stopped by Suspend.
+func (cmd *Cmd) Resume() error {
+    if err := cmd.setSuspended(false); err != nil {
+        return err
+    }
+    cmd.out.Push(NewResumedMessage())
+    return nil
+}
+
+func (cmd *Cmd) setSuspended(suspend bool) error {
+    p, err := cmd.process()
+    if err != nil {
+        return err
+    } else if err := cmd.opts.suspend(p, suspend); errors.Is(err, os.ErrProcessDone) {
+        return ErrClosed
+    } else {
+        return err
+    }
+}
@@ -0,0 +1,8 @@
+//go:build !unix && !windows
+
+package subflow
+
+import "os"
+
+// suspend stops or continues the process p, which is not supported.
+func (o *options) suspend(*os.Process, bool) error { return unsupported("suspend") }
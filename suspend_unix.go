@@ -0,0 +1,16 @@
+//go:build unix
+
+package subflow
+
+import (
+    "os"
+    "syscall"
+)
+
+// suspend stops or continues the process p, or its whole process group with WithProcessGroup.
+func (o *options) suspend(p *os.Process, suspend bool) error {
+    if suspend {
+        return o.signal(p, syscall.SIGSTOP)
+    }
+    return o.signal(p, syscall.SIGCONT)
+}
@@ -0,0 +1,35 @@
+//go:build windows
+
+package subflow
+
+import (
+    "fmt"
+    "os"
+    "syscall"
+)
+
+var (
+    ntdll                = syscall.NewLazyDLL("ntdll.dll")
+    procNtSuspendProcess = ntdll.NewProc("NtSuspendProcess")
+    procNtResumeProcess  = ntdll.NewProc("NtResumeProcess")
+)
+
+const processSuspendResume = 0x0800
+
+// suspend suspends or resumes all threads of the process p.
+func (o *options) suspend(p *os.Process, suspend bool) error {
+    h, err := syscall.OpenProcess(processSuspendResume, false, uint32(p.Pid))
+    if err != nil {
+        return fmt.Errorf("open process: %w", err)
+    }
+    defer syscall.CloseHandle(h)
+    proc := procNtResumeProcess
+    if suspend {
+        proc = procNtSuspendProcess
+    }
+    // The functions return an NTSTATUS, which is 0 on success.
+    if status, _, _ := proc.Call(uintptr(h)); status != 0 {
+        return fmt.Errorf("%s: status %#x", proc.Name, status)
+    }
+    return nil
+}
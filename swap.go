@@ -0,0 +1,139 @@
+package subflow
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net"
+    "os"
+    "os/exec"
+    "time"
+)
+
+// ErrNotReady is returned by Swap when the new command exits before becoming ready.
+var ErrNotReady = errors.New("command exited before becoming ready")
+
+// defaultSwapGrace is how long Swap waits for the old command to exit after os.Interrupt before it is killed.
+const defaultSwapGrace = 10 * time.Second
+
+// SwapOption configures Swap.
+type SwapOption func(*swapOptions)
+
+type swapOptions struct {
+    term     os.Signal
+    grace    time.Duration
+    listener net.Listener
+}
+
+// WithSwapGrace stops the old command by sending term and waiting up to grace for it to exit before it is killed,
+// instead of sending os.Interrupt and waiting up to 10 seconds.
+func WithSwapGrace(term os.Signal, grace time.Duration) SwapOption {
+    return func(so *swapOptions) {
+        so.term = term
+        so.grace = grace
+    }
+}
+
+// WithSwapListener hands a duplicate of the listening socket l over to the new command, after the files of WithExtraFiles.
+// The descriptor in the new command is reported in the ExtraFiles of its StartMessage.
+// The new command must not have been started before Swap and l must provide its file, like *net.TCPListener and *net.UnixListener.
+func WithSwapListener(l net.Listener) SwapOption {
+    return func(so *swapOptions) { so.listener = l }
+}
+
+// Swap replaces the running command old with next without downtime.
+// next is started and ready is called to wait until it can take over, then old is stopped gracefully, see WithSwapGrace.
+// A nil ready considers next ready as soon as it was started and did not exit right away.
+// If next fails to start, ready fails or next exits first, next is closed and old keeps running.
+// Each step is reported with a SwapMessage on the output of both commands, old reports SwapDone right before it is closed
+// and next once old has exited.
+func Swap(ctx context.Context, old, next *Cmd, ready func(context.Context, *Cmd) error, opts ...SwapOption) error {
+    so := swapOptions{term: os.Interrupt, grace: defaultSwapGrace}
+    for _, opt := range opts {
+        opt(&so)
+    }
+    push := func(step SwapStep) {
+        msg := NewSwapMessage(step)
+        old.out.Push(msg)
+        next.out.Push(msg)
+    }
+
+    abort := func(err error) error {
+        push(SwapAborted)
+        return errors.Join(fmt.Errorf("swap: %w", err), next.Close())
+    }
+
+    var handed *os.File
+    if so.listener != nil {
+        f, err := handOver(next, so.listener)
+        if err != nil {
+            return abort(err)
+        }
+        handed = f
+    }
+    err := next.StartErr()
+    if handed != nil {
+        // The child has inherited the listener, or will never start.
+        err = errors.Join(err, handed.Close())
+    }
+    if err != nil {
+        return abort(err)
+    }
+    push(SwapStarted)
+    if err := waitReady(ctx, next, ready); err != nil {
+        return abort(err)
+    }
+    push(SwapReady)
+
+    push(SwapStopping)
+    old.out.Push(NewSwapMessage(SwapDone))
+    // The old command is expected to exit with an error when it is stopped, it is not a failure of the swap.
+    _ = old.CloseGraceful(so.term, so.grace)
+    next.out.Push(NewSwapMessage(SwapDone))
+    return nil
+}
+
+// handOver passes a duplicate of the file of l to cmd once it is started.
+// The returned duplicate is closed by the caller once cmd was started.
+func handOver(cmd *Cmd, l net.Listener) (*os.File, error) {
+    if cmd.started.Load() {
+        return nil, errors.New("listener handover needs the new command to not be started")
+    }
+    fl, ok := l.(interface{ File() (*os.File, error) })
+    if !ok {
+        return nil, fmt.Errorf("listener handover of %T: %w", l, errors.ErrUnsupported)
+    }
+    f, err := fl.File()
+    if err != nil {
+        return nil, fmt.Errorf("listener handover: %w", err)
+    }
+    cmd.OnBeforeStart(func(c *exec.Cmd) error {
+        cmd.res.extraFds = append(cmd.res.extraFds, 3+len(c.ExtraFiles))
+        c.ExtraFiles = append(c.ExtraFiles, f)
+        return nil
+    })
+    return f, nil
+}
+
+// waitReady calls ready until it returns or cmd exits, a nil ready only checks that cmd has not exited.
+func waitReady(ctx context.Context, cmd *Cmd, ready func(context.Context, *Cmd) error) error {
+    if ready == nil {
+        select {
+        case <-cmd.Done():
+            return ErrNotReady
+        default:
+            return nil
+        }
+    }
+
+    ctx, cancel := context.WithCancel(ctx)
+    defer cancel()
+    errc := make(chan error, 1)
+    go func() { errc <- ready(ctx, cmd) }()
+    select {
+    case err := <-errc:
+        return err
+    case <-cmd.Done():
+        return ErrNotReady
+    }
+}
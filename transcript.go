@@ -0,0 +1,107 @@
+package subflow
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "strconv"
+    "strings"
+)
+
+var _ Sink = (*Transcript)(nil)
+
+// Transcript is a Sink rendering the messages of a command into a deterministic text transcript,
+// so transcripts of different runs can be compared with diff.
+// Every line holds a sequence number, the stream or message kind, and its content.
+// Stdio is split into lines, and times and durations are left out.
+//
+//	0001 start
+//	0002 stdout hello
+//	0003 stderr warning: no config
+//	0004 exit   code=0 reason=normal actor=process
+type Transcript struct {
+    w     io.Writer
+    seq   int
+    lines lineDecoder
+    err   error
+}
+
+// NewTranscript returns a Transcript writing to w.
+func NewTranscript(w io.Writer) *Transcript {
+    return &Transcript{w: w}
+}
+
+func (t *Transcript) Write(msg Message) error {
+    t.lines.decode(msg, t.line)
+    return t.err
+}
+
+// Close writes the incomplete lines left, it does not close the underlying writer.
+func (t *Transcript) Close() error {
+    t.lines.flush(t.line)
+    return t.err
+}
+
+// line writes the transcript line of msg.
+func (t *Transcript) line(msg Message) bool {
+    if t.err != nil {
+        return false
+    }
+    kind, content := transcriptLine(msg)
+    t.seq++
+    line := fmt.Sprintf("%04d %-6s %s", t.seq, kind, content)
+    _, t.err = io.WriteString(t.w, strings.TrimRight(line, " ")+"\n")
+    return t.err == nil
+}
+
+func transcriptLine(msg Message) (kind, content string) {
+    switch msg := msg.(type) {
+    case StartMessage:
+        return "start", ""
+    case StdinMessage:
+        return "stdin", stdioLine(msg.Data)
+    case StdoutMessage:
+        return "stdout", stdioLine(msg.Data)
+    case StderrMessage:
+        return "stderr", stdioLine(msg.Data)
+    case ExitMessage:
+        return "exit", fmt.Sprintf("code=%d reason=%s actor=%s", msg.Code, msg.Reason, msg.Actor)
+    case SignalMessage:
+        return "signal", msg.Signal
+    case SwapMessage:
+        return "swap", string(msg.Step)
+    case FirstOutputMessage:
+        return "first", ""
+    }
+    return transcriptJSON(msg)
+}
+
+// stdioLine returns a line of stdio without its newline, an incomplete line is marked.
+func stdioLine(data []byte) string {
+    if line, ok := bytes.CutSuffix(data, []byte("\n")); ok {
+        return string(line)
+    }
+    return string(data) + ` \ no newline`
+}
+
+// transcriptJSON returns the kind and remaining fields of msg without its time.
+func transcriptJSON(msg Message) (kind, content string) {
+    b, err := json.Marshal(msg)
+    if err != nil {
+        return "?", strconv.Quote(err.Error())
+    }
+    var fields map[string]json.RawMessage
+    if err := json.Unmarshal(b, &fields); err != nil {
+        return "?", strconv.Quote(err.Error())
+    }
+    _ = json.Unmarshal(fields["kind"], &kind)
+    delete(fields, "kind")
+    delete(fields, "time")
+    if len(fields) == 0 {
+        return kind, ""
+    }
+    // Maps are marshalled with sorted keys.
+    b, _ = json.Marshal(fields)
+    return kind, string(b)
+}
@@ -0,0 +1,36 @@
+package subflow
+
+import "time"
+
+// reportUsage emits a UsageMessage every interval of WithUsage until stop is called.
+func (cmd *Cmd) reportUsage() (stop func()) {
+    if cmd.opts.usage <= 0 {
+        return func() {}
+    }
+    return cmd.report(cmd.opts.usage, newUsageSampler(cmd.cmd.Process.Pid))
+}
+
+// report pushes the message returned by sample every interval until stop is called, it does nothing if interval is not positive.
+func (cmd *Cmd) report(interval time.Duration, sample func() Message) (stop func()) {
+    if interval <= 0 {
+        return func() {}
+    }
+    done, stopped := make(chan struct{}), make(chan struct{})
+    go func() {
+        defer close(stopped)
+        t := time.NewTicker(interval)
+        defer t.Stop()
+        for {
+            select {
+            case <-done:
+                return
+            case <-t.C:
+                cmd.out.Push(sample())
+            }
+        }
+    }()
+    return func() {
+        close(done)
+        <-stopped
+    }
+}
@@ -0,0 +1,74 @@
+//go:build linux
+
+package subflow
+
+import (
+    "bufio"
+    "bytes"
+    "os"
+    "strconv"
+    "time"
+)
+
+// clockTicks is the number of clock ticks per second the CPU times in /proc are counted in, which is fixed on Linux.
+const clockTicks = 100
+
+// newUsageSampler returns a function sampling the usage of the process pid from /proc.
+// The CPU usage is measured since the previous sample, the first one since the sampler was created.
+func newUsageSampler(pid int) func() Message {
+    dir := "/proc/" + strconv.Itoa(pid)
+    last, lastCPU := time.Now(), procCPU(dir)
+    return func() Message {
+        now, cpu := time.Now(), procCPU(dir)
+        var percent float64
+        if elapsed := now.Sub(last); elapsed > 0 {
+            percent = float64(cpu-lastCPU) / float64(elapsed) * 100
+        }
+        last, lastCPU = now, cpu
+
+        var rss int64
+        if b, err := os.ReadFile(dir + "/statm"); err == nil {
+            if f := bytes.Fields(b); len(f) > 1 {
+                pages, _ := strconv.ParseInt(string(f[1]), 10, 64)
+                rss = pages * int64(os.Getpagesize())
+            }
+        }
+        fds, _ := os.ReadDir(dir + "/fd")
+        read, write := procIO(dir)
+        return NewUsageMessage(percent, rss, len(fds), read, write)
+    }
+}
+
+// procCPU returns the user and system CPU time of the process of the /proc directory dir.
+func procCPU(dir string) time.Duration {
+    b, err := os.ReadFile(dir + "/stat")
+    if err != nil {
+        return 0
+    }
+    // The command name may contain spaces and parentheses, the fields follow its last parenthesis.
+    f := bytes.Fields(b[bytes.LastIndexByte(b, ')')+1:])
+    if len(f) < 13 {
+        return 0
+    }
+    utime, _ := strconv.ParseInt(string(f[11]), 10, 64)
+    stime, _ := strconv.ParseInt(string(f[12]), 10, 64)
+    return time.Duration(utime+stime) * time.Second / clockTicks
+}
+
+// procIO returns the bytes read from and written to storage by the process of the /proc directory dir.
+func procIO(dir string) (read, write int64) {
+    b, err := os.ReadFile(dir + "/io")
+    if err != nil {
+        return 0, 0
+    }
+    for s := bufio.NewScanner(bytes.NewReader(b)); s.Scan(); {
+        k, v, _ := bytes.Cut(s.Bytes(), []byte(": "))
+        switch string(k) {
+        case "read_bytes":
+            read, _ = strconv.ParseInt(string(v), 10, 64)
+        case "write_bytes":
+            write, _ = strconv.ParseInt(string(v), 10, 64)
+        }
+    }
+    return read, write
+}
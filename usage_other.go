@@ -0,0 +1,6 @@
+//go:build !linux
+
+package subflow
+
+// newUsageSampler returns a function sampling the usage of the process pid, usage messages are only supported on Linux.
+func newUsageSampler(int) func() Message { return nil }
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package subflow
+
+import "os/exec"
+
+// setUser runs c as the user name.
+func setUser(*exec.Cmd, string) error {
+    return unsupported("running as another user")
+}
+
+// setCredential runs c as the user uid.
+func setCredential(*exec.Cmd, uint32, uint32, []uint32) error {
+    return unsupported("credential")
+}
@@ -0,0 +1,53 @@
+//go:build unix
+
+package subflow
+
+import (
+    "fmt"
+    "os/exec"
+    "os/user"
+    "strconv"
+    "syscall"
+)
+
+// setUser runs c as the user name, a username or numeric user id, with its primary and supplementary groups.
+func setUser(c *exec.Cmd, name string) error {
+    u, err := user.Lookup(name)
+    if _, nerr := strconv.ParseUint(name, 10, 32); err != nil && nerr == nil {
+        u, err = user.LookupId(name)
+    }
+    if err != nil {
+        return fmt.Errorf("lookup user: %w", err)
+    }
+
+    uid, err := strconv.ParseUint(u.Uid, 10, 32)
+    if err != nil {
+        return fmt.Errorf("parse uid of %s: %w", name, err)
+    }
+    gid, err := strconv.ParseUint(u.Gid, 10, 32)
+    if err != nil {
+        return fmt.Errorf("parse gid of %s: %w", name, err)
+    }
+    ids, err := u.GroupIds()
+    if err != nil {
+        return fmt.Errorf("lookup groups of %s: %w", name, err)
+    }
+    groups := make([]uint32, 0, len(ids))
+    for _, id := range ids {
+        if g, err := strconv.ParseUint(id, 10, 32); err == nil {
+            groups = append(groups, uint32(g))
+        }
+    }
+
+    return setCredential(c, uint32(uid), uint32(gid), groups)
+}
+
+// setCredential runs c as the user uid with the primary group gid and the supplementary groups.
+func setCredential(c *exec.Cmd, uid, gid uint32, groups []uint32) error {
+    sysProcAttr(c).Credential = &syscall.Credential{
+        Uid:    uid,
+        Gid:    gid,
+        Groups: groups,
+    }
+    return nil
+}
@@ -0,0 +1,55 @@
+package subflow
+
+import (
+    "context"
+    "errors"
+    "fmt"
+)
+
+// WaitAll waits for all cmds to complete and returns their errors joined, each prefixed with the path of its command.
+// It returns early with the error of ctx if ctx is done first, the commands keep running.
+func WaitAll(ctx context.Context, cmds ...*Cmd) (err error) {
+    for _, cmd := range cmds {
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-cmd.Done():
+            err = errors.Join(err, cmd.result())
+        }
+    }
+    return err
+}
+
+// WaitAny waits for the first of cmds to complete and returns it with its error.
+// It returns the error of ctx if ctx is done first, without stopping any of the commands.
+// Without cmds it waits for ctx.
+func WaitAny(ctx context.Context, cmds ...*Cmd) (*Cmd, error) {
+    ctx, cancel := context.WithCancel(ctx)
+    defer cancel()
+
+    first := make(chan *Cmd, len(cmds))
+    for _, cmd := range cmds {
+        go func() {
+            select {
+            case <-ctx.Done():
+            case <-cmd.Done():
+                first <- cmd
+            }
+        }()
+    }
+
+    select {
+    case <-ctx.Done():
+        return nil, ctx.Err()
+    case cmd := <-first:
+        return cmd, cmd.result()
+    }
+}
+
+// result returns the error of a completed command, prefixed with the path of its command.
+func (cmd *Cmd) result() error {
+    if cmd.waitErr == nil {
+        return nil
+    }
+    return fmt.Errorf("%s: %w", cmd.cmd.Path, cmd.waitErr)
+}
@@ -0,0 +1,27 @@
+package subflow
+
+import (
+    "context"
+    "errors"
+    "os"
+    "strings"
+    "syscall"
+    "testing"
+)
+
+func TestWorkspaceWithPrivateTmp(t *testing.T) {
+    if os.Geteuid() != 0 {
+        t.Skip("mounts need root")
+    }
+    // The quota is tiny so writing past it shows the workspace tmpfs is the one the child sees.
+    out := Run(context.Background(), NewCommandArgs("sh", []string{"-c", `[ "$(pwd)" = "$TMPDIR" ] || exit 3; touch f || exit 4; head -c 100000 /dev/zero > big && exit 5; exit 0`}),
+        nil, WithWorkspace(64*1024), WithPrivateTmp())
+    if err := out.Err(); errors.Is(err, syscall.EPERM) {
+        t.Skipf("mounts are not permitted: %v", err)
+    } else if err != nil {
+        t.Fatalf("%v, code %d", err, out.Code())
+    }
+    if stderr := string(out.Stderr()); !strings.Contains(stderr, "No space left") {
+        t.Errorf("stderr = %q, want the workspace quota to be exceeded", stderr)
+    }
+}